@@ -18,7 +18,9 @@ package v1alpha1
 // Important: Action "make" to regenerate code after modifying this file.
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
 // ClusterAddonSpec defines the desired state of a target k8s cluster.
@@ -36,6 +38,54 @@ type ClusterAddonSpec struct {
 
 	// Sources is the map of repositories and run actions to perform on the target k8s cluster.
 	Sources map[string]ClusterAddonSource `json:"sources,omitempty"`
+
+	// Suspend stops the reconciliation of the ClusterAddon; the target cluster and its state are left untouched
+	// and the sources aren't polled.
+	// A suspended ClusterAddon that is deleted leaves the cluster add-ons in place.
+	// +optional
+	Suspend bool `json:"suspend,omitempty"`
+
+	// Mode specifies whether changes are applied to the target cluster.
+	// Valid values are:
+	// - "Apply" (default): apply changes;
+	// - "Plan": show the changes in status.sources[].plan but leave the target cluster untouched.
+	// +optional
+	Mode ClusterAddonMode `json:"mode,omitempty"`
+
+	// Retry specifies how the failed actions of sources are retried.
+	// +optional
+	Retry *RetryPolicy `json:"retry,omitempty"`
+}
+
+// ClusterAddonMode describes whether changes are applied to the target cluster.
+// +kubebuilder:validation:Enum=Apply;Plan
+type ClusterAddonMode string
+
+const (
+	// ModeApply applies changes to the target cluster.
+	ModeApply ClusterAddonMode = "Apply"
+
+	// ModePlan shows the changes that would be applied.
+	ModePlan ClusterAddonMode = "Plan"
+)
+
+// RetryPolicy specifies how failed actions are retried with exponential backoff.
+type RetryPolicy struct {
+	// MinBackoff is the time to wait before the first retry (default 30s), it doubles with each failure.
+	// +optional
+	MinBackoff *metav1.Duration `json:"minBackoff,omitempty"`
+
+	// MaxBackoff is the max time to wait before a retry (default 5m).
+	// +optional
+	MaxBackoff *metav1.Duration `json:"maxBackoff,omitempty"`
+
+	// +kubebuilder:validation:Minimum=0
+
+	// MaxFailures is the number of failures after which an action is no longer retried until the source commit
+	// or the action changes.
+	// Zero (default) retries forever.
+	// +optional
+	MaxFailures int `json:"maxFailures,omitempty"`
 }
 
 // ClusterAddonPolicy describes how the cluster addons will be updated or deleted.
@@ -57,27 +107,55 @@ const (
 
 type ClusterAddonTarget struct {
 	// URL is the URL of the API Server.
-	URL string `json:"url"`
+	// +optional
+	URL string `json:"url,omitempty"`
 	// CACert is the CA of the API Server base64 encoded.
-	CACert []byte `json:"caCert"`
+	// +optional
+	CACert []byte `json:"caCert,omitempty"`
 	// User is the username (used together with password) to authenticate.
 	// +optional
 	User string `json:"user,omitempty"`
 	// Password is the user password base64 encoded.
+	// Deprecated: use SecretRef.
 	// +optional
 	Password string `json:"password,omitempty"`
 	// ClientCert is the certificate (used together with ClientKey) to authenticate.
 	// +optional
 	ClientCert []byte `json:"clientCert,omitempty"`
 	// ClientKey is the ClientCert key base64 encoded.
+	// Deprecated: use SecretRef.
 	// +optional
 	ClientKey []byte `json:"clientKey,omitempty"`
+
+	// SecretRef refers to a Secret in the ClusterAddon namespace with credentials to access the API Server.
+	// The Secret keys 'caCert', 'user', 'password', 'clientCert' and 'clientKey' take precedence over
+	// the target fields with the same name.
+	// +optional
+	SecretRef *corev1.LocalObjectReference `json:"secretRef,omitempty"`
+
+	// KubeconfigSecretRef refers to a Secret key in the ClusterAddon namespace with a kubeconfig to access
+	// the API Server.
+	// When specified all other target fields are ignored.
+	// +optional
+	KubeconfigSecretRef *SecretKeyReference `json:"kubeconfigSecretRef,omitempty"`
+}
+
+// SecretKeyReference refers to a key in a Secret in the ClusterAddon namespace.
+type SecretKeyReference struct {
+	// Name of the Secret.
+	Name string `json:"name"`
+	// Key in the Secret.
+	Key string `json:"key"`
 }
 
 type ClusterAddonSource struct {
 	// Type is the type of repository to use as a source.
 	// Valid values are:
 	// - "git" (default): GIT repository.
+	// - "oci": artifact in an OCI registry.
+	// - "http": (gzipped) tar archive at an HTTP server.
+	// - "configmap": files in a ConfigMap.
+	// - "inline": files in the source.
 	// +optional
 	Type ClusterAddonSourceType `json:"type,omitempty"`
 
@@ -85,19 +163,69 @@ type ClusterAddonSource struct {
 
 	// URL is the URL of the repo that is available at $REPOROOT during the Action.
 	// When Token is specified the URL is expected to start with 'https://'.
-	URL string `json:"url"`
+	// For Type=oci the URL is 'oci://host/repository' ('http://host/repository' for a registry without TLS).
+	// For Type=http the URL is the location of the archive.
+	// Required for all types except configmap and inline.
+	// +optional
+	URL string `json:"url,omitempty"`
 
 	// +kubebuilder:validation:MinLength=2
 
 	// Branch is the repo branch to get.
-	Branch string `json:"branch"`
+	// For Type=git either Branch, Tag or SemverRange is required.
+	// +optional
+	Branch string `json:"branch,omitempty"`
+
+	// Tag is the tag to get.
+	// For Type=git the commit the tag refers to is checked out.
+	// For Type=oci (default "latest") the digest the tag refers to is used as the commit SHA.
+	// +optional
+	Tag string `json:"tag,omitempty"`
+
+	// +kubebuilder:validation:Pattern=`^[0-9a-fA-F]{7,40}$`
+
+	// Revision pins the source to a commit SHA on Branch (Type=git).
+	// The commit is checked out once, the remote isn't polled for new commits.
+	// +optional
+	Revision string `json:"revision,omitempty"`
+
+	// SemverRange selects the highest tag that is a semantic version within the range, for example '>=1.4 <2'
+	// (Type=git).
+	// +optional
+	SemverRange string `json:"semverRange,omitempty"`
+
+	// +kubebuilder:validation:Pattern=`^(sha256:)?[0-9a-fA-F]{64}$`
+
+	// SHA256 is the expected checksum of the archive (Type=http).
+	// An archive that doesn't match is rejected, an archive that matches is only downloaded once.
+	// +optional
+	SHA256 string `json:"sha256,omitempty"`
+
+	// ConfigMapRef refers to a ConfigMap in the ClusterAddon namespace with the files of the source (Type=configmap).
+	// Each key is a file name.
+	// +optional
+	ConfigMapRef *corev1.LocalObjectReference `json:"configMapRef,omitempty"`
+
+	// Files maps file paths to the content of the files of the source (Type=inline).
+	// +optional
+	Files map[string]string `json:"files,omitempty"`
 
 	// Token is used to authenticate with the remote server.
 	// For Type=git;
 	// - Token or ~/.ssh key should be specified (azure devops requires the token to be prefixed with 'x:')
+	// For Type=oci;
+	// - Token is 'username:password' to authenticate with the registry.
+	// For Type=http;
+	// - Token is 'username:password' for basic authentication or a bearer token.
+	// Deprecated: use TokenSecretRef.
 	// +optional
 	Token string `json:"token,omitempty"`
 
+	// TokenSecretRef refers to a Secret key in the ClusterAddon namespace with the Token.
+	// It takes precedence over Token.
+	// +optional
+	TokenSecretRef *SecretKeyReference `json:"tokenSecretRef,omitempty"`
+
 	// Action specifies what to do when the content of the repository changes.
 	Action ClusterAddonAction `json:"action"`
 }
@@ -105,41 +233,149 @@ type ClusterAddonSource struct {
 // ClusterAddonSourceType is the type of repository to use as a source.
 // Valid values are:
 // - SourceTypeGIT (default)
-// +kubebuilder:validation:Enum=git
+// - SourceTypeOCI
+// - SourceTypeHTTP
+// - SourceTypeConfigMap
+// - SourceTypeInline
+// +kubebuilder:validation:Enum=git;oci;http;configmap;inline
 type ClusterAddonSourceType string
 
 const (
 	// SourceTypeGIT specifies a source repository of type GIT.
 	SourceTypeGIT ClusterAddonSourceType = "git"
+
+	// SourceTypeOCI specifies a source artifact in an OCI registry.
+	SourceTypeOCI ClusterAddonSourceType = "oci"
+
+	// SourceTypeHTTP specifies a source archive at an HTTP server.
+	SourceTypeHTTP ClusterAddonSourceType = "http"
+
+	// SourceTypeConfigMap specifies source files in a ConfigMap.
+	SourceTypeConfigMap ClusterAddonSourceType = "configmap"
+
+	// SourceTypeInline specifies source files in the ClusterAddon.
+	SourceTypeInline ClusterAddonSourceType = "inline"
 )
 
 type ClusterAddonAction struct {
 	// Type is the type of action to perform when the repository has changed.
 	// Valid values are:
 	// - "shell" (default): Action shell with 'cmd' and 'values'.
+	// - "manifests": Apply the manifests at 'path' rendered with 'values'.
+	// - "helm": Install or upgrade the chart at 'path' as 'release' in 'namespace' with 'values'.
+	// - "kustomize": Apply the kustomization at 'path' with 'values' as ConfigMap and vars.
+	// - "template": Apply the YAML files under 'path' rendered as Go template with 'values' and target metadata.
 	// +optional
 	Type ClusterAddonActionType `json:"type,omitempty"`
 
 	// +kubebuilder:validation:MinLength=2
 
-	// Cmd specifies what command to run in the shell.
-	Cmd string `json:"cmd"`
+	// Cmd specifies what command to run in the shell (type shell).
+	// $RECONCILE is set to CREATE_OR_UPDATE.
+	// +optional
+	Cmd string `json:"cmd,omitempty"`
+
+	// DeleteCmd specifies what command to run in the shell when the ClusterAddon is deleted.
+	// It runs in the same environment as Cmd with $RECONCILE set to DELETE.
+	// When DeleteCmd is empty the cluster add-ons are left in place.
+	// +optional
+	DeleteCmd string `json:"deleteCmd,omitempty"`
+
+	// PlanCmd specifies what command to run in the shell in Plan mode, for example 'kubectl diff -f $REPODIR/deploy/'.
+	// It runs in the same environment as Cmd with $RECONCILE set to PLAN.
+	// Exit code 0 means no changes, 1 means changes and other codes mean failure (like kubectl diff).
+	// When PlanCmd is empty actions of type manifests, kustomize and template are planned with a server-side dry-run.
+	// +optional
+	PlanCmd string `json:"planCmd,omitempty"`
+
+	// Image is the container image in which Cmd and DeleteCmd run as a Kubernetes Job in the ClusterAddon namespace
+	// (type shell).
+	// The image must contain bash and the tools Cmd needs.
+	// When Image is empty Cmd runs in the operator.
+	// Image can't be combined with PlanCmd.
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// Timeout is the max duration of the action (default 15m), when it takes longer the commands are killed.
+	// For an action with an Image it's the active deadline of the Job.
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+
+	// Path is the path relative to the repository root of a YAML file or a directory with YAML files (type manifests),
+	// a chart (type helm), a kustomization (type kustomize) or a directory tree with templates (type template).
+	// +optional
+	Path string `json:"path,omitempty"`
+
+	// Render renders the YAML files as Go template with the values as .Values before they're applied (type manifests).
+	// Without Render the files are applied as-is, also when the action has values.
+	// +optional
+	Render bool `json:"render,omitempty"`
+
+	// Release is the name of the Helm release (type helm), it defaults to the source name.
+	// +optional
+	Release string `json:"release,omitempty"`
+
+	// Namespace is the namespace of the Helm release (type helm), it defaults to 'default'.
+	// For type kustomize it's the namespace of the values ConfigMap.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// RollbackOnFailure rolls a Helm release back to the previous revision when an upgrade fails (type helm).
+	// A failed first install is uninstalled.
+	// +optional
+	RollbackOnFailure bool `json:"rollbackOnFailure,omitempty"`
+
+	// Values is an arbitrary structure that is passed as values.yaml to the shell.
+	// Values are also passed as environment variables with the path to each value flattened,
+	// for example {a: {b: [x]}} results in VALUE_A_B_0=x
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +optional
+	Values *runtime.RawExtension `json:"values,omitempty"`
 
-	// Values are key-value pairs that are passed as values.yaml and environment
-	// variables to the shell.
+	// ValuesFrom are ConfigMaps and Secrets in the ClusterAddon namespace with key-value pairs that are
+	// merged with Values.
+	// When a key exists in multiple sources the last source wins, Values take precedence over ValuesFrom.
 	// +optional
-	Values map[string]string `json:"values,omitempty"`
+	ValuesFrom []ValuesFromSource `json:"valuesFrom,omitempty"`
+}
+
+// ValuesFromSource selects a ConfigMap or Secret to populate values from.
+type ValuesFromSource struct {
+	// Prefix is prepended to each key of the ConfigMap or Secret.
+	// +optional
+	Prefix string `json:"prefix,omitempty"`
+	// ConfigMapRef selects a ConfigMap.
+	// +optional
+	ConfigMapRef *corev1.LocalObjectReference `json:"configMapRef,omitempty"`
+	// SecretRef selects a Secret.
+	// +optional
+	SecretRef *corev1.LocalObjectReference `json:"secretRef,omitempty"`
+	// Optional specifies that the ConfigMap or Secret doesn't need to exist.
+	// +optional
+	Optional bool `json:"optional,omitempty"`
 }
 
 // ClusterAddonActionType is the type of action to run when the repository has changed.
 // Valid values are:
 // - RunTypeShell (default)
-// +kubebuilder:validation:Enum=shell
+// - RunTypeManifests
+// - RunTypeHelm
+// - RunTypeKustomize
+// - RunTypeTemplate
+// +kubebuilder:validation:Enum=shell;manifests;helm;kustomize;template
 type ClusterAddonActionType string
 
 const (
 	// RunTypeShell specifies that a bash shell will be used.
 	RunTypeShell ClusterAddonActionType = "shell"
+	// RunTypeManifests specifies that manifests will be applied with server-side apply.
+	RunTypeManifests ClusterAddonActionType = "manifests"
+	// RunTypeHelm specifies that a Helm chart will be installed or upgraded.
+	RunTypeHelm ClusterAddonActionType = "helm"
+	// RunTypeKustomize specifies that a kustomization will be built and applied with server-side apply.
+	RunTypeKustomize ClusterAddonActionType = "kustomize"
+	// RunTypeTemplate specifies that templates will be rendered and applied with server-side apply.
+	RunTypeTemplate ClusterAddonActionType = "template"
 )
 
 // ClusterAddonStatus defines the observed state of a clusteraddon.
@@ -151,6 +387,144 @@ type ClusterAddonStatus struct {
 	// Synced is true when the source/action have been applied successfully.
 	// +optional
 	Synced metav1.ConditionStatus `json:"synced,omitempty"`
+
+	// Sources is the status of each source by name.
+	// +optional
+	Sources map[string]SourceStatus `json:"sources,omitempty"`
+
+	// LastForceSync is the value of the last force-sync annotation that has been handled.
+	// +optional
+	LastForceSync string `json:"lastForceSync,omitempty"`
+}
+
+// SourceStatus is the observed state of a source and its action.
+type SourceStatus struct {
+	// AppliedSHA is the source commit that has been applied to the target cluster.
+	// +optional
+	AppliedSHA string `json:"appliedSHA,omitempty"`
+
+	// AvailableSHA is the latest source commit.
+	// When it differs from AppliedSHA an update is pending.
+	// +optional
+	AvailableSHA string `json:"availableSHA,omitempty"`
+
+	// Version is the tag of the latest source commit when the source follows tags.
+	// +optional
+	Version string `json:"version,omitempty"`
+
+	// ActionHash is the hash of the action and values that have been applied to the target cluster.
+	// +optional
+	ActionHash string `json:"actionHash,omitempty"`
+
+	// LastAttemptTime is the last time the action has been run.
+	// +optional
+	LastAttemptTime *metav1.Time `json:"lastAttemptTime,omitempty"`
+
+	// LastSuccessTime is the last time the action has been run successfully.
+	// +optional
+	LastSuccessTime *metav1.Time `json:"lastSuccessTime,omitempty"`
+
+	// Duration is the time the last run of the action took.
+	// +optional
+	Duration *metav1.Duration `json:"duration,omitempty"`
+
+	// LastError is the error of the last reconcile of this source, empty when the reconcile succeeded.
+	// +optional
+	LastError string `json:"lastError,omitempty"`
+
+	// Output is the tail of the combined stdout and stderr of the last run of the action.
+	// Secrets are masked.
+	// +optional
+	Output string `json:"output,omitempty"`
+
+	// Objects are the results of applying each object of the last run of the action (type manifests).
+	// +optional
+	Objects []ObjectStatus `json:"objects,omitempty"`
+
+	// Helm is the status of the Helm release (type helm).
+	// +optional
+	Helm *HelmReleaseStatus `json:"helm,omitempty"`
+
+	// Failures is the number of consecutive failures of the action for FailedRevision.
+	// +optional
+	Failures int `json:"failures,omitempty"`
+
+	// FailedRevision is the source commit and action hash that failed.
+	// When the source or action changes the failures are reset.
+	// +optional
+	FailedRevision string `json:"failedRevision,omitempty"`
+
+	// NextRetry is the time after which a failed action is retried.
+	// +optional
+	NextRetry *metav1.Time `json:"nextRetry,omitempty"`
+
+	// Plan shows the changes that would be applied (Plan mode).
+	// +optional
+	Plan *SourcePlan `json:"plan,omitempty"`
+}
+
+// SourcePlan shows the changes that applying a source would make to the target cluster.
+type SourcePlan struct {
+	// SHA is the source commit that has been planned.
+	SHA string `json:"sha,omitempty"`
+
+	// ActionHash is the hash of the action and values that have been planned.
+	ActionHash string `json:"actionHash,omitempty"`
+
+	// Time is when the plan has been made.
+	// +optional
+	Time *metav1.Time `json:"time,omitempty"`
+
+	// Changes is true when applying the source would change the target cluster.
+	Changes bool `json:"changes"`
+
+	// Diff is the (truncated) output of the plan, the full output is in the run log.
+	// Secrets are masked.
+	// +optional
+	Diff string `json:"diff,omitempty"`
+}
+
+// HelmReleaseStatus is the status of a Helm release in the target cluster.
+type HelmReleaseStatus struct {
+	// Release is the name of the release.
+	Release string `json:"release"`
+	// Namespace of the release.
+	Namespace string `json:"namespace"`
+	// Revision is the last revision of the release.
+	Revision int `json:"revision"`
+	// Status of the last revision, for example deployed or failed.
+	Status string `json:"status"`
+	// RolledBack is true when the last upgrade failed and the release has been rolled back to the previous revision.
+	// +optional
+	RolledBack bool `json:"rolledBack,omitempty"`
+}
+
+// ObjectResult is the result of applying an object.
+type ObjectResult string
+
+const (
+	// ObjectApplied means the object has been applied successfully.
+	ObjectApplied ObjectResult = "Applied"
+	// ObjectFailed means applying the object failed.
+	ObjectFailed ObjectResult = "Failed"
+)
+
+// ObjectStatus is the result of applying an object to the target cluster.
+type ObjectStatus struct {
+	// APIVersion of the object.
+	APIVersion string `json:"apiVersion"`
+	// Kind of the object.
+	Kind string `json:"kind"`
+	// Namespace of the object, empty for cluster scoped objects.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+	// Name of the object.
+	Name string `json:"name"`
+	// Result is one of Applied or Failed.
+	Result ObjectResult `json:"result"`
+	// Message tells why the apply failed.
+	// +optional
+	Message string `json:"message,omitempty"`
 }
 
 type ClusterAddonConditionType string
@@ -165,6 +539,8 @@ const (
 	ClusterAddonActionOk ClusterAddonConditionType = "ActionOk"
 	// ClusterAddonSynced means the source/action have been applied successfully.
 	ClusterAddonSynced ClusterAddonConditionType = "Synced"
+	// ClusterAddonSuspended means the reconciliation has been suspended.
+	ClusterAddonSuspended ClusterAddonConditionType = "Suspended"
 )
 
 // ClusterAddonCondition is one of;
@@ -172,6 +548,7 @@ const (
 // - SourceOk
 // - ActionOk
 // - Synced
+// - Suspended
 type ClusterAddonCondition struct {
 	// Type of clusteraddon condition, Complete or Failed.
 	Type ClusterAddonConditionType `json:"type,omitempty"`
@@ -195,6 +572,7 @@ type ClusterAddonCondition struct {
 // +kubebuilder:object:root=true
 // +kubebuilder:printcolumn:name="Target",type=string,JSONPath=`.spec.target.url`
 // +kubebuilder:printcolumn:name="Synced",type=string,JSONPath=`.status.synced`
+// +kubebuilder:printcolumn:name="Suspended",type=boolean,JSONPath=`.spec.suspend`
 // +kubebuilder:subresource:status
 
 // ClusterAddon is the Schema for the clusteraddons API
@@ -0,0 +1,392 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	v1alpha1 "github.com/mmlt/operator-addons/api/v1alpha1"
+	"github.com/mmlt/operator-addons/internal/cluster"
+	"github.com/mmlt/operator-addons/internal/helm"
+	"github.com/mmlt/operator-addons/internal/kustomize"
+	"github.com/mmlt/operator-addons/internal/manifest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// ActionEnv is the environment in which an action runs.
+type actionEnv struct {
+	// Source is the name of the source.
+	source string
+	// Dir is the directory with the source contents.
+	dir string
+	// Version is the version (tag) of the source contents, if any.
+	version string
+	// Revision is the commit (or digest) of the source contents.
+	revision string
+	// Values are the action values with the ValuesFrom ConfigMaps and Secrets merged in.
+	values map[string]interface{}
+	// SecretKeys are the top-level values that come from Secrets.
+	secretKeys map[string]bool
+	// Labels are the ownership labels that are added to the objects the operator applies.
+	labels map[string]string
+	// Target is the target cluster metadata.
+	target targetInfo
+	// Delete is true when the source is torn down, a Job then runs DeleteCmd instead of Cmd.
+	delete bool
+
+	log logr.Logger
+}
+
+// TargetInfo is the target cluster metadata that is available in templates as .Target
+type targetInfo struct {
+	// Name and Namespace of the ClusterAddon.
+	Name      string
+	Namespace string
+	// URL of the target API Server.
+	URL string
+}
+
+// ActionResult is the outcome of an action.
+type actionResult struct {
+	// Output is the combined stdout and stderr of the action.
+	output string
+	// Objects are the results per object for actions that apply objects.
+	objects []v1alpha1.ObjectStatus
+	// Inventory are the objects the action has applied, nil when they are unknown.
+	inventory []cluster.ObjectRef
+	// Helm is the status of the release for helm actions.
+	helm *v1alpha1.HelmReleaseStatus
+}
+
+// DefaultActionTimeout is the max duration of an action that doesn't specify a timeout.
+const DefaultActionTimeout = 15 * time.Minute
+
+// ActionTimeout returns the max duration of action.
+func actionTimeout(action *v1alpha1.ClusterAddonAction) time.Duration {
+	if action.Timeout != nil && action.Timeout.Duration > 0 {
+		return action.Timeout.Duration
+	}
+	return DefaultActionTimeout
+}
+
+// InventoryFile is the name of the file in which a shell action can report the objects it has applied.
+const inventoryFile = "inventory.yaml"
+
+// HelmRecoveryTimeout is the max duration of the rollback or uninstall of a failed helm action and of getting the
+// release status, it includes the 5m helm rollback waits for the resources by default.
+const helmRecoveryTimeout = 10 * time.Minute
+
+// RunAction performs action in the target cluster.
+// Commands are killed when the action timeout has passed or ctx is done.
+// The result is also returned when the action fails.
+func runAction(ctx context.Context, cl *cluster.Cluster, action *v1alpha1.ClusterAddonAction, env *actionEnv) (*actionResult, error) {
+	if action.Type == v1alpha1.RunTypeHelm {
+		// InstallChart limits the upgrade to the action timeout, recovering a failed upgrade gets its own time.
+		return installChart(ctx, cl, action, env)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, actionTimeout(action))
+	defer cancel()
+
+	switch action.Type {
+	case v1alpha1.RunTypeManifests, v1alpha1.RunTypeKustomize, v1alpha1.RunTypeTemplate:
+		objs, err := objectsFor(action, env)
+		if err != nil {
+			return &actionResult{}, err
+		}
+		return applyObjects(cl, objs)
+	default:
+		return runShell(ctx, cl, action, env)
+	}
+}
+
+// ObjectsFor returns the objects an action of type manifests, kustomize or template applies.
+func objectsFor(action *v1alpha1.ClusterAddonAction, env *actionEnv) ([]*unstructured.Unstructured, error) {
+	var objs []*unstructured.Unstructured
+	var err error
+	switch action.Type {
+	case v1alpha1.RunTypeManifests:
+		objs, err = readManifests(action, env)
+	case v1alpha1.RunTypeKustomize:
+		objs, err = buildKustomization(action, env)
+	case v1alpha1.RunTypeTemplate:
+		objs, err = renderTemplates(action, env)
+	default:
+		return nil, fmt.Errorf("action of type %s has no objects", action.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Label objs with the ownership labels.
+	for _, obj := range objs {
+		labels := obj.GetLabels()
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		for k, v := range env.labels {
+			labels[k] = v
+		}
+		obj.SetLabels(labels)
+	}
+
+	return objs, nil
+}
+
+// RunShell runs the action cmd in a shell.
+// The cmd can report the objects it applies by writing them to the file $INVENTORY.
+func runShell(ctx context.Context, cl *cluster.Cluster, action *v1alpha1.ClusterAddonAction, env *actionEnv) (*actionResult, error) {
+	if action.Cmd == "" {
+		return &actionResult{}, fmt.Errorf("action of type shell requires cmd")
+	}
+
+	inv := filepath.Join(cl.Path, inventoryFile)
+	err := os.Remove(inv)
+	if err != nil && !os.IsNotExist(err) {
+		return &actionResult{}, err
+	}
+
+	out, err := cl.RunShell(ctx, action.Cmd, env.values, []string{"REPODIR=" + env.dir, "SOURCE_VERSION=" + env.version, "RECONCILE=CREATE_OR_UPDATE", "INVENTORY=" + inv})
+	r := &actionResult{output: out}
+	if err != nil {
+		return r, err
+	}
+
+	r.inventory, err = readInventory(inv)
+	return r, err
+}
+
+// ReadInventory returns the references to the objects in the YAML file at path.
+// Lists (like the output of 'kubectl apply -o yaml') are expanded.
+// Nil is returned when the file doesn't exist.
+func readInventory(path string) ([]cluster.ObjectRef, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	objs, err := manifest.Decode(b)
+	if err != nil {
+		return nil, fmt.Errorf("inventory: %w", err)
+	}
+
+	refs := []cluster.ObjectRef{}
+	for _, obj := range objs {
+		if obj.IsList() {
+			err := obj.EachListItem(func(o runtime.Object) error {
+				refs = append(refs, cluster.RefOf(o.(*unstructured.Unstructured)))
+				return nil
+			})
+			if err != nil {
+				return nil, fmt.Errorf("inventory: %w", err)
+			}
+			continue
+		}
+		refs = append(refs, cluster.RefOf(obj))
+	}
+
+	return refs, nil
+}
+
+// ReadManifests returns the manifests at the action path, rendered with values when the action asks for it.
+func readManifests(action *v1alpha1.ClusterAddonAction, env *actionEnv) ([]*unstructured.Unstructured, error) {
+	objs, err := manifest.Read(env.dir, action.Path, action.Render, env.values)
+	if err != nil {
+		return nil, fmt.Errorf("manifests: %w", err)
+	}
+	return objs, nil
+}
+
+// RenderTemplates returns the YAML files in the directory tree at the action path rendered with values and target
+// metadata.
+func renderTemplates(action *v1alpha1.ClusterAddonAction, env *actionEnv) ([]*unstructured.Unstructured, error) {
+	data := map[string]interface{}{
+		"Values": env.values,
+		"Target": env.target,
+	}
+	objs, err := manifest.RenderAll(env.dir, action.Path, data)
+	if err != nil {
+		return nil, fmt.Errorf("template: %w", err)
+	}
+	return objs, nil
+}
+
+// ReasonFor returns the condition reason for an action error.
+func reasonFor(err error) string {
+	var re *manifest.RenderError
+	switch {
+	case errors.As(err, &re):
+		return "RenderFailed"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "Timeout"
+	}
+	return "Error"
+}
+
+// BuildKustomization returns the objects of the kustomization at the action path built with values.
+// The values are added as ConfigMap <source>-values in the action namespace, values from Secrets as Secret <source>-values.
+func buildKustomization(action *v1alpha1.ClusterAddonAction, env *actionEnv) ([]*unstructured.Unstructured, error) {
+	dir, err := manifest.Join(env.dir, action.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	return kustomize.Build(dir, env.values, kustomize.ValuesConfigMap{
+		Name:       env.source + "-values",
+		Namespace:  action.Namespace,
+		SecretKeys: env.secretKeys,
+	})
+}
+
+// ApplyObjects applies objs to the target cluster.
+// The output lists the result of each object like kubectl apply does.
+func applyObjects(cl *cluster.Cluster, objs []*unstructured.Unstructured) (*actionResult, error) {
+	results, err := cl.Apply(objs)
+	if err != nil {
+		return &actionResult{}, fmt.Errorf("apply: %w", err)
+	}
+
+	out, objects, err := objectStatus(results)
+	r := &actionResult{output: out, objects: objects, inventory: []cluster.ObjectRef{}}
+	for _, ar := range results {
+		r.inventory = append(r.inventory, cluster.RefOf(ar.Object))
+	}
+
+	return r, err
+}
+
+// InstallChart installs or upgrades the chart at the action path as Helm release with values as chart values.
+// When the upgrade fails and RollbackOnFailure is set, the release is rolled back to the previous revision or
+// uninstalled when the failed upgrade was the first install.
+// The upgrade is limited to the action timeout, the recovery and status to helmRecoveryTimeout.
+func installChart(ctx context.Context, cl *cluster.Cluster, action *v1alpha1.ClusterAddonAction, env *actionEnv) (*actionResult, error) {
+	chart, err := manifest.Join(env.dir, action.Path)
+	if err != nil {
+		return &actionResult{}, fmt.Errorf("helm: %w", err)
+	}
+	release, namespace := helmRelease(action, env.source)
+
+	h := &helm.Helm{Home: cl.Path, Log: env.log}
+
+	uctx, cancel := context.WithTimeout(ctx, actionTimeout(action))
+	out, err := h.Upgrade(uctx, release, namespace, chart, env.values)
+	cancel()
+	r := &actionResult{output: out}
+	// Rollback and report also when the upgrade has timed out.
+	cctx, cancel := context.WithTimeout(ctx, helmRecoveryTimeout)
+	defer cancel()
+	var rolledBack bool
+	if err != nil && action.RollbackOnFailure {
+		rel, _ := h.Status(cctx, release, namespace)
+		switch {
+		case rel != nil && rel.Revision > 1:
+			o, rerr := h.Rollback(cctx, release, namespace)
+			r.output += o
+			if rerr != nil {
+				r.output += rerr.Error() + "\n"
+			}
+			rolledBack = rerr == nil
+		case rel != nil:
+			// There is no previous revision to roll back to.
+			o, rerr := h.Uninstall(cctx, release, namespace)
+			r.output += o
+			if rerr != nil {
+				r.output += rerr.Error() + "\n"
+			}
+		}
+	}
+
+	rel, serr := h.Status(cctx, release, namespace)
+	if rel != nil {
+		r.helm = &v1alpha1.HelmReleaseStatus{
+			Release:    rel.Name,
+			Namespace:  rel.Namespace,
+			Revision:   rel.Revision,
+			Status:     rel.Status,
+			RolledBack: rolledBack,
+		}
+	}
+
+	if err != nil {
+		return r, fmt.Errorf("helm: %w", err)
+	}
+	if serr != nil {
+		return r, fmt.Errorf("helm: %w", serr)
+	}
+	return r, nil
+}
+
+// UninstallChart uninstalls the Helm release of action of source n.
+// It returns the output of helm, a release that doesn't exist is not an error.
+func uninstallChart(ctx context.Context, cl *cluster.Cluster, action *v1alpha1.ClusterAddonAction, n string, log logr.Logger) (string, error) {
+	release, namespace := helmRelease(action, n)
+	h := &helm.Helm{Home: cl.Path, Log: log}
+
+	rel, err := h.Status(ctx, release, namespace)
+	if err != nil {
+		return "", fmt.Errorf("helm: %w", err)
+	}
+	if rel == nil {
+		return fmt.Sprintf("release %s not found in namespace %s\n", release, namespace), nil
+	}
+
+	out, err := h.Uninstall(ctx, release, namespace)
+	if err != nil {
+		return out, fmt.Errorf("helm: %w", err)
+	}
+	return out, nil
+}
+
+// HelmRelease returns the release name and namespace of the helm action of source n.
+func helmRelease(action *v1alpha1.ClusterAddonAction, n string) (string, string) {
+	release := action.Release
+	if release == "" {
+		release = n
+	}
+	namespace := action.Namespace
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+	}
+	return release, namespace
+}
+
+// ObjectStatus returns the output and object status of apply results.
+// An error is returned when one or more objects failed to apply.
+func objectStatus(results []cluster.ApplyResult) (string, []v1alpha1.ObjectStatus, error) {
+	var out strings.Builder
+	var objects []v1alpha1.ObjectStatus
+	var failed int
+	for _, r := range results {
+		o := v1alpha1.ObjectStatus{
+			APIVersion: r.Object.GetAPIVersion(),
+			Kind:       r.Object.GetKind(),
+			Namespace:  r.Object.GetNamespace(),
+			Name:       r.Object.GetName(),
+			Result:     v1alpha1.ObjectApplied,
+		}
+		if r.Err != nil {
+			o.Result = v1alpha1.ObjectFailed
+			o.Message = r.Err.Error()
+			failed++
+		}
+		objects = append(objects, o)
+		fmt.Fprintln(&out, strings.TrimSpace(fmt.Sprintf("%s/%s %s %s", strings.ToLower(o.Kind), o.Name, strings.ToLower(string(o.Result)), o.Message)))
+	}
+
+	if failed > 0 {
+		return out.String(), objects, fmt.Errorf("apply: %d of %d objects failed", failed, len(results))
+	}
+
+	return out.String(), objects, nil
+}
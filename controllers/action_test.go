@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mmlt/operator-addons/api/v1alpha1"
+	"github.com/mmlt/operator-addons/internal/cluster"
+	"github.com/mmlt/operator-addons/internal/manifest"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func Test_objectStatus(t *testing.T) {
+	obj := func(kind, name string) *unstructured.Unstructured {
+		o := &unstructured.Unstructured{}
+		o.SetAPIVersion("v1")
+		o.SetKind(kind)
+		o.SetNamespace("default")
+		o.SetName(name)
+		return o
+	}
+	results := []cluster.ApplyResult{
+		{Object: obj("ConfigMap", "a")},
+		{Object: obj("Secret", "b"), Err: errors.New("forbidden")},
+	}
+
+	out, objects, err := objectStatus(results)
+
+	if err == nil {
+		t.Error("expected error")
+	}
+	wantOut := "configmap/a applied\nsecret/b failed forbidden\n"
+	if out != wantOut {
+		t.Errorf("got output %q, want %q", out, wantOut)
+	}
+	wantObjects := []v1alpha1.ObjectStatus{
+		{APIVersion: "v1", Kind: "ConfigMap", Namespace: "default", Name: "a", Result: v1alpha1.ObjectApplied},
+		{APIVersion: "v1", Kind: "Secret", Namespace: "default", Name: "b", Result: v1alpha1.ObjectFailed, Message: "forbidden"},
+	}
+	if !cmp.Equal(objects, wantObjects) {
+		t.Errorf("diff (- = got, + = want) %s", cmp.Diff(objects, wantObjects))
+	}
+}
+
+func Test_readInventory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	got, err := readInventory(filepath.Join(dir, "missing.yaml"))
+	if err != nil || got != nil {
+		t.Errorf("got %v %v, want nil inventory for a missing file", got, err)
+	}
+
+	// A List like 'kubectl apply -o yaml' writes and a plain manifest.
+	p := filepath.Join(dir, inventoryFile)
+	err = ioutil.WriteFile(p, []byte(`
+apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: ConfigMap
+  metadata:
+    namespace: default
+    name: a
+---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: x
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = readInventory(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []cluster.ObjectRef{
+		{APIVersion: "v1", Kind: "ConfigMap", Namespace: "default", Name: "a"},
+		{APIVersion: "v1", Kind: "Namespace", Name: "x"},
+	}
+	if !cmp.Equal(got, want) {
+		t.Errorf("diff (- = got, + = want) %s", cmp.Diff(got, want))
+	}
+}
+
+func Test_reasonFor(t *testing.T) {
+	renderErr := fmt.Errorf("template: %w", &manifest.RenderError{Name: "a.yaml", Err: errors.New("bad")})
+	if got := reasonFor(renderErr); got != "RenderFailed" {
+		t.Errorf("got %q, want RenderFailed", got)
+	}
+	timeoutErr := fmt.Errorf("timeout after 1m0s: %w", context.DeadlineExceeded)
+	if got := reasonFor(timeoutErr); got != "Timeout" {
+		t.Errorf("got %q, want Timeout", got)
+	}
+	if got := reasonFor(errors.New("boom")); got != "Error" {
+		t.Errorf("got %q, want Error", got)
+	}
+}
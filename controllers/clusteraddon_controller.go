@@ -17,20 +17,36 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"github.com/mitchellh/hashstructure"
 	"github.com/mmlt/operator-addons/internal/cluster"
+	"github.com/mmlt/operator-addons/internal/repofiles"
 	"github.com/mmlt/operator-addons/internal/repogit"
+	"github.com/mmlt/operator-addons/internal/repohttp"
+	"github.com/mmlt/operator-addons/internal/repooci"
+	"github.com/mmlt/operator-addons/internal/runlog"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/tools/record"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	v1alpha1 "github.com/mmlt/operator-addons/api/v1alpha1"
 )
@@ -38,9 +54,16 @@ import (
 // https://kubernetes.io/docs/tasks/access-kubernetes-api/custom-resources/custom-resource-definitions/#finalizers
 const finalizerName = "clusteraddon.clusterops.mmlt.nl"
 
+// AnnotationSkipTeardown set to "true" lets a ClusterAddon whose target credentials are gone be deleted without
+// tearing down its cluster add-ons.
+const annotationSkipTeardown = "clusterops.mmlt.nl/skip-teardown"
+
 // RequeueDurection is the interval with which external resources are checked for changes.
 const RequeueDuration = 5 * time.Minute
 
+// RepoUpdateTimeout is the max duration of getting the content of a source from its remote server.
+const RepoUpdateTimeout = 5 * time.Minute
+
 // ClusterAddonReconciler reconciles a ClusterAddon object.
 type ClusterAddonReconciler struct {
 	client.Client
@@ -48,22 +71,58 @@ type ClusterAddonReconciler struct {
 	Scheme   *runtime.Scheme
 	recorder record.EventRecorder
 
-	// Repos maps the repository url's to repo data.
-	Repos map[string]*repogit.Repo
+	// Repos maps namespace/clusteraddon/source/repository to repo data.
+	// Each source has its own repo so the token of one ClusterAddon is never used to get the source of another.
+	Repos map[string]Repo
+
+	// Runlog keeps the output of the last action runs.
+	Runlog *runlog.Runlog
+
+	// JobGitImage is the image that clones a git source into the pod of a Job.
+	// When empty the source content is copied into the Job via a ConfigMap.
+	JobGitImage string
+
+	// PodLogs returns the logs of a pod.
+	podLogs func(namespace, name string) ([]byte, error)
+
+	// Ctx is cancelled when the manager stops so running actions are killed.
+	ctx context.Context
+}
+
+// Repo is the local copy of the content of a source.
+type Repo interface {
+	// SetToken sets the token that is used to authenticate with the remote server.
+	SetToken(token string) error
+	// Update updates the local copy to the latest revision at the remote server.
+	Update(ctx context.Context) error
+	// SHAlocal returns the revision of the local copy.
+	SHAlocal() (string, error)
+	// Dir returns the absolute path to the local copy.
+	Dir() string
+	// Version returns the version (tag) of the local copy, if any.
+	Version() string
+	// Remove removes the local copy.
+	Remove() error
 }
 
 // +kubebuilder:rbac:groups=clusterops.mmlt.nl,resources=clusteraddons,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=clusterops.mmlt.nl,resources=clusteraddons/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;delete
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;delete
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;delete;deletecollection
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=pods/log,verbs=get
 
 // Reconcile attempts to apply desired state.
 func (r *ClusterAddonReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
-	ctx := context.Background()
+	ctx := r.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
 	log := r.Log.WithValues("clusteraddon", req.NamespacedName.String())
 	log.V(1).Info("Reconcile start")
 	defer log.V(1).Info("Reconcile end")
 
-	// TODO add Policy checks
-
 	// Get ClusterAddon resource.
 	clusterAddon := &v1alpha1.ClusterAddon{}
 	if err := r.Get(ctx, req.NamespacedName, clusterAddon); err != nil {
@@ -71,9 +130,32 @@ func (r *ClusterAddonReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error
 		return ctrl.Result{}, ignoreNotFound(err)
 	}
 
+	// Leave everything untouched while suspended.
+	if clusterAddon.Spec.Suspend {
+		return r.suspended(ctx, clusterAddon, log)
+	}
+
 	// Get Cluster object.
-	cl, err := r.clusterFor(req.Namespace+"-"+req.Name, &clusterAddon.Spec.Target, log)
+	cl, err := r.clusterFor(ctx, clusterAddon, log)
+	if err != nil && apierrors.IsNotFound(err) && !clusterAddon.ObjectMeta.DeletionTimestamp.IsZero() {
+		// The CR is being deleted but the target credentials are gone (for example because the namespace is
+		// being deleted) so the cluster add-ons can't be torn down.
+		// The finalizer is kept unless the user explicitly accepts leaving the cluster add-ons in place.
+		if clusterAddon.Annotations[annotationSkipTeardown] == "true" {
+			return ctrl.Result{}, r.removeFinalizer(clusterAddon, err, log)
+		}
+		m := fmt.Sprintf("Cluster add-ons can't be torn down, target credentials: %v (annotate with %s=true to delete without teardown)",
+			err, annotationSkipTeardown)
+		r.recorder.Event(clusterAddon, corev1.EventTypeWarning, "DeleteBlocked", m)
+		err = errors.New(m)
+	}
 	if err != nil {
+		status := &v1alpha1.ClusterAddonStatus{Conditions: []v1alpha1.ClusterAddonCondition{
+			condition(v1alpha1.ClusterAddonTargetOk, false, "Credentials", err.Error()),
+		}}
+		if err := r.updateStatus(ctx, clusterAddon, status, log); err != nil {
+			log.Error(err, "Status update")
+		}
 		return ctrl.Result{}, fmt.Errorf("clusterFor: %w", err)
 	}
 
@@ -82,13 +164,23 @@ func (r *ClusterAddonReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error
 		// The CR is (being) deleted.
 		if containsString(clusterAddon.ObjectMeta.Finalizers, finalizerName) {
 			// Finalizer is present, proceed with delete.
-			err = r.delete(cl, log)
+			status, err := r.delete(ctx, cl, clusterAddon, log)
 			if err != nil {
 				// Delete failed (but will be retried).
+				// The finalizer is kept, the status tells why.
+				if err := r.updateStatus(ctx, clusterAddon, status, log); err != nil {
+					log.Error(err, "Status update")
+				}
+				if errors.Is(err, errJobRunning) {
+					// The Job that finishes triggers the next reconcile.
+					return ctrl.Result{}, nil
+				}
 				return ctrl.Result{}, fmt.Errorf("delete (will retry): %w", err)
 			}
 
 			// Deletion succeeded, remove our finalizer.
+			r.removeRepos(path.Join(clusterAddon.Namespace, clusterAddon.Name), "", log)
+			r.removeRunlog(path.Join(clusterAddon.Namespace, clusterAddon.Name), log)
 			clusterAddon.ObjectMeta.Finalizers = removeString(clusterAddon.ObjectMeta.Finalizers, finalizerName)
 			err = r.Update(context.Background(), clusterAddon)
 			if err != nil {
@@ -110,35 +202,68 @@ func (r *ClusterAddonReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error
 	}
 
 	// Do the actual reconciliation work.
-	status, err := r.createOrUpdate(cl, clusterAddon, log)
+	status, err := r.createOrUpdate(ctx, cl, clusterAddon, log)
 	if err != nil {
 		return ctrl.Result{}, err
 	}
 	log.V(1).Info("Status from createOrUpdate", "status", status)
+	status.Conditions = append(status.Conditions, condition(v1alpha1.ClusterAddonSuspended, false, "", ""))
+
+	err = r.updateStatus(ctx, clusterAddon, status, log)
+	//TODO remove
+	if err != nil {
+		log.Error(err, "Status update")
+	}
+
+	// Failed actions are retried at the time set by their backoff.
+	return ctrl.Result{RequeueAfter: requeueAfter(status, time.Now())}, err
+}
 
-	changed := calculateStatus(clusterAddon, status, time.Now())
-	if !changed {
-		return ctrl.Result{RequeueAfter: RequeueDuration}, nil
+// Suspended handles a suspended clusterAddon.
+// The target cluster isn't accessed and no requeue is requested so the sources aren't polled.
+// A suspended clusterAddon that is deleted gets its finalizer removed, the cluster add-ons are left in place.
+func (r *ClusterAddonReconciler) suspended(ctx context.Context, clusterAddon *v1alpha1.ClusterAddon, log logr.Logger) (ctrl.Result, error) {
+	if !clusterAddon.ObjectMeta.DeletionTimestamp.IsZero() {
+		if containsString(clusterAddon.ObjectMeta.Finalizers, finalizerName) {
+			clusterAddon.ObjectMeta.Finalizers = removeString(clusterAddon.ObjectMeta.Finalizers, finalizerName)
+			err := r.Update(ctx, clusterAddon)
+			if err != nil {
+				return ctrl.Result{}, fmt.Errorf("finalizer remove: %w", err)
+			}
+			log.Info("Finalizer removed, cluster add-ons are left in place because the ClusterAddon is suspended")
+		}
+		return ctrl.Result{}, nil
 	}
 
-	// Write updated clusterAddon/status.
-	ctx = context.Background()
-	err = r.Status().Update(ctx, clusterAddon)
+	log.V(1).Info("Suspended")
+	status := &v1alpha1.ClusterAddonStatus{Conditions: []v1alpha1.ClusterAddonCondition{
+		condition(v1alpha1.ClusterAddonSuspended, true, "Suspended", "Reconciliation is suspended"),
+	}}
+	err := r.updateStatus(ctx, clusterAddon, status, log)
 
-	//TODO remove
+	return ctrl.Result{}, err
+}
+
+// UpdateStatus merges status into clusterAddon and writes it to the API Server when it has changed.
+func (r *ClusterAddonReconciler) updateStatus(ctx context.Context, clusterAddon *v1alpha1.ClusterAddon, status *v1alpha1.ClusterAddonStatus, log logr.Logger) error {
+	if status == nil || !calculateStatus(clusterAddon, status, time.Now()) {
+		return nil
+	}
+
+	err := r.Status().Update(ctx, clusterAddon)
 	if err != nil {
-		log.Error(err, "Status update")
+		return err
 	}
 	log.V(2).Info("Status updated")
 
-	//TODO don't return err==nil when condition is false
-	return ctrl.Result{RequeueAfter: RequeueDuration}, err
+	return nil
 }
 
 // CreateOrUpdate creates or updates resources as defined in clusterAddon in the target cluster.
 // Errors are mapped to status fields/conditions when possible.
 // Only the errors that can't be mapped are returned.
 func (r *ClusterAddonReconciler) createOrUpdate(
+	ctx context.Context,
 	cl *cluster.Cluster,
 	clusterAddon *v1alpha1.ClusterAddon,
 	log logr.Logger) (*v1alpha1.ClusterAddonStatus, error) {
@@ -165,19 +290,35 @@ func (r *ClusterAddonReconciler) createOrUpdate(
 		return status, nil
 	}
 	status.Conditions = append(status.Conditions, condition(v1alpha1.ClusterAddonTargetOk, true, "", ""))
+	status.Sources = make(map[string]v1alpha1.SourceStatus, len(clusterAddon.Spec.Sources))
 
 	// Iterate over Sources.
+	// A force-sync request runs the actions although nothing has changed.
+	forceToken, forceSources := forceSync(clusterAddon)
+	var forcePending bool
+
 	var hasStateChange bool
 	for n, src := range clusterAddon.Spec.Sources {
 		log := log.WithValues("source", n)
+		force := forced(forceToken, forceSources, n)
+
+		// Start with the previous source status so the times of earlier runs are kept.
+		ss := clusterAddon.Status.Sources[n]
+		ss.LastError = ""
+		if clusterAddon.Spec.Mode != v1alpha1.ModePlan {
+			ss.Plan = nil
+		}
+		status.Sources[n] = ss
 
 		// Get repo.
-		repo, err := r.repoFor(&src, log)
+		repo, err := r.repoFor(ctx, clusterAddon, n, &src, log)
 		if err != nil {
 			//TODO DRY? status.Conditions = r.xxx(status.Conditions, v1alpha1.ClusterAddonSourceOk, "Get source", err)
 			//TODO Keep condition, event and log together?
 			// logRecordCondition(...)
 			status.Conditions = append(status.Conditions, condition(v1alpha1.ClusterAddonSourceOk, false, "Error", err.Error()))
+			setSourceError(status, n, err)
+			forcePending = forcePending || force
 			log.Error(err, "Get source")
 			r.recorder.Event(clusterAddon, corev1.EventTypeWarning, "UpdateFailed", fmt.Sprintf("Update %s failed", n))
 			log.Info(fmt.Sprintf("Update %s failed", n))
@@ -186,47 +327,244 @@ func (r *ClusterAddonReconciler) createOrUpdate(
 		status.Conditions = append(status.Conditions, condition(v1alpha1.ClusterAddonSourceOk, true, "", ""))
 		log.V(2).Info("Get source")
 
+		// Get values.
+		values, secretKeys, err := r.valuesFor(ctx, clusterAddon.Namespace, &src.Action)
+		if err != nil {
+			status.Conditions = append(status.Conditions, condition(v1alpha1.ClusterAddonActionOk, false, "ValuesFrom", err.Error()))
+			setSourceError(status, n, err)
+			forcePending = forcePending || force
+			log.Error(err, "Get values")
+			r.recorder.Event(clusterAddon, corev1.EventTypeWarning, "UpdateFailed", fmt.Sprintf("Update '%s' failed", n))
+			continue
+		}
+
 		// Check for changes in repo or action.
 		repoSHA, _ := repo.SHAlocal()
-		actionHash, err := hashstructure.Hash(src.Action, nil)
+		ss.AvailableSHA = repoSHA
+		ss.Version = repo.Version()
+		status.Sources[n] = ss
+		hash, err := actionHash(&src.Action, values)
 		if err != nil {
 			return status, err
 		}
-		if repoSHA == currentState.Sources[n].RepoSHA && actionHash == currentState.Sources[n].ActionHash {
-			// no changes.
+		desired := sourceState{ActionHash: hash, RepoSHA: repoSHA, Source: withoutCredentials(&src),
+			Inventory: currentState.Sources[n].Inventory}
+		op := operationFor(currentState, n, desired)
+		if op == opNone && force {
+			op = opUpdate
+		}
+		if op == opNone {
+			resetFailures(&ss)
+			ss.Plan = nil
+			status.Sources[n] = ss
+			// No changes but keep the source that's used for teardown up-to-date.
+			if !equality.Semantic.DeepEqual(currentState.Sources[n].Source, desired.Source) {
+				currentState.Sources[n] = desired
+				hasStateChange = true
+			}
 			continue
 		}
 
+		owner := ownerLabels(clusterAddon, n)
+		env := &actionEnv{
+			source:     n,
+			dir:        repo.Dir(),
+			version:    repo.Version(),
+			revision:   repoSHA,
+			values:     values,
+			secretKeys: secretKeys,
+			labels:     owner,
+			target:     targetInfo{Name: clusterAddon.Name, Namespace: clusterAddon.Namespace, URL: cl.Server},
+			log:        log,
+		}
+
+		if clusterAddon.Spec.Mode == v1alpha1.ModePlan {
+			// Show the changes but leave the target cluster and its state untouched.
+			if force {
+				ss.Plan = nil
+			}
+			c := r.plan(ctx, cl, clusterAddon, n, &src, env, desired, currentState.Sources[n], &ss, log)
+			status.Conditions = append(status.Conditions, c)
+			status.Sources[n] = ss
+			continue
+		}
+
+		// Plan mode doesn't change the target cluster, the policy only applies when applying.
+		if !allowed(clusterAddon.Spec.Policy, op) {
+			// Report the pending change but leave the target cluster untouched.
+			change := pendingChange(currentState.Sources[n], desired)
+			if change == "" {
+				change = "force-sync"
+			}
+			m := fmt.Sprintf("%s '%s' denied by policy %s: %s", op, n, clusterAddon.Spec.Policy, change)
+			status.Conditions = append(status.Conditions, condition(v1alpha1.ClusterAddonSynced, false, "UpdateDenied", m))
+			r.recorder.Event(clusterAddon, corev1.EventTypeWarning, "UpdateDenied", m)
+			log.Info(m)
+			continue
+		}
+
+		// Wait for the backoff of an earlier failure.
+		rev := revision(desired)
+		if reason, m := retryBlocked(&ss, clusterAddon.Spec.Retry, rev, time.Now()); reason != "" && !force {
+			ss.LastError = clusterAddon.Status.Sources[n].LastError
+			status.Sources[n] = ss
+			m = fmt.Sprintf("Update '%s' %s: %s", n, m, ss.LastError)
+			status.Conditions = append(status.Conditions, condition(v1alpha1.ClusterAddonActionOk, false, reason, m))
+			log.V(1).Info(m)
+			continue
+		}
+		var attempt int
+		if ss.FailedRevision == rev {
+			attempt = ss.Failures
+		}
+		var forceRun string
+		if force {
+			forceRun = forceToken
+		}
+
 		// Perform action.
-		env := []string{"REPODIR=" + repo.Dir()} //TODO add RECONCILE=CREATE_OR_UPDATE ?
-		err = cl.RunShell(src.Action.Cmd, src.Action.Values, env)
+		start := metav1.Now().Rfc3339Copy()
+		var end time.Time
+		var res *actionResult
+		var reported bool
+		if src.Action.Image == "" {
+			timeout := actionTimeout(&src.Action)
+			res, err = runAction(ctx, cl, &src.Action, env)
+			if errors.Is(err, context.DeadlineExceeded) {
+				err = fmt.Errorf("timeout after %s: %w", timeout, err)
+			}
+		} else {
+			var run *jobRun
+			run, res, err = r.runJob(ctx, cl, clusterAddon, &src, env, jobName(clusterAddon, n, desired, attempt, forceRun))
+			if err == nil && run.running {
+				// The force-sync request is handled when the Job has finished.
+				forcePending = forcePending || force
+				m := fmt.Sprintf("Job %s of '%s' is running", run.name, n)
+				status.Conditions = append(status.Conditions, condition(v1alpha1.ClusterAddonSynced, false, "JobRunning", m))
+				log.V(1).Info(m)
+				continue
+			}
+			start, end = metav1.NewTime(run.start).Rfc3339Copy(), run.end
+			// A failed Job is reported again on each reconcile, its output only needs to be kept once.
+			reported = ss.LastAttemptTime != nil && ss.LastAttemptTime.Equal(&start)
+		}
+		out := res.output
+		var pruneErr error
+		if err == nil {
+			// Prune the objects that are no longer applied.
+			var pruneOut string
+			desired.Inventory, pruneOut, pruneErr = prune(cl, clusterAddon.Spec.Policy, currentState.Sources[n].Inventory, res.inventory, owner)
+			out += pruneOut
+		}
+		if end.IsZero() {
+			end = time.Now()
+		}
+		ss.LastAttemptTime = &start
+		ss.Duration = &metav1.Duration{Duration: end.Sub(start.Time).Round(time.Millisecond)}
+		if !reported {
+			ss.Output = r.output(ctx, clusterAddon, n, &src, start.Time, out, log)
+		}
+		ss.Objects = res.objects
+		ss.Helm = res.helm
+		if err == nil {
+			ss.LastSuccessTime = &start
+			resetFailures(&ss)
+		} else if !reported {
+			recordFailure(&ss, clusterAddon.Spec.Retry, rev, end)
+		}
+		status.Sources[n] = ss
 		if err != nil {
-			status.Conditions = append(status.Conditions, condition(v1alpha1.ClusterAddonActionOk, false, "Error", err.Error()))
+			status.Conditions = append(status.Conditions, condition(v1alpha1.ClusterAddonActionOk, false, reasonFor(err), err.Error()))
+			setSourceError(status, n, err)
+			if reported {
+				continue
+			}
 			log.Error(err, "Action")
 			r.recorder.Event(clusterAddon, corev1.EventTypeWarning, "UpdateFailed", fmt.Sprintf("Update '%s' failed", n))
 			log.Info(fmt.Sprintf("Update '%s' failed", n))
 			continue
 		}
 		status.Conditions = append(status.Conditions, condition(v1alpha1.ClusterAddonActionOk, true, "", ""))
+		if pruneErr != nil {
+			// The action has been applied but some objects that are no longer applied remain.
+			status.Conditions = append(status.Conditions, condition(v1alpha1.ClusterAddonActionOk, false, "PruneFailed", pruneErr.Error()))
+			setSourceError(status, n, pruneErr)
+			log.Error(pruneErr, "Prune")
+			r.recorder.Event(clusterAddon, corev1.EventTypeWarning, "PruneFailed", fmt.Sprintf("Prune '%s' failed", n))
+		}
 
 		//TODO DRY event+log combination
 		r.recorder.Event(clusterAddon, corev1.EventTypeNormal, "Update", fmt.Sprintf("Update '%s' successful", n))
 		log.Info(fmt.Sprintf("Update '%s' successful", n))
 
 		// Update current state
-		currentState.Sources[n] = sourceState{ActionHash: actionHash, RepoSHA: repoSHA}
+		currentState.Sources[n] = desired
+		hasStateChange = true
+	}
+
+	// Teardown sources that have been removed from the spec.
+	for _, n := range removedSources(currentState, clusterAddon.Spec.Sources) {
+		log := log.WithValues("source", n)
+
+		if clusterAddon.Spec.Mode == v1alpha1.ModePlan {
+			m := fmt.Sprintf("Source '%s' removed, cluster add-ons are left in place in Plan mode", n)
+			status.Conditions = append(status.Conditions, condition(v1alpha1.ClusterAddonSynced, false, "PlanReady", m))
+			log.V(1).Info(m)
+			continue
+		}
+
+		var m string
+		if src := currentState.Sources[n].Source; !allowed(clusterAddon.Spec.Policy, opDelete) {
+			m = fmt.Sprintf("Source '%s' removed, cluster add-ons are left in place by policy %s", n, clusterAddon.Spec.Policy)
+		} else if src == nil {
+			m = fmt.Sprintf("Source '%s' removed, cluster add-ons are left in place (no teardown information)", n)
+		} else {
+			err := r.teardown(ctx, cl, clusterAddon, n, src, currentState.Sources[n].Inventory, log)
+			if errors.Is(err, errJobRunning) {
+				m := fmt.Sprintf("Delete Job of '%s' is running", n)
+				status.Conditions = append(status.Conditions, condition(v1alpha1.ClusterAddonSynced, false, "JobRunning", m))
+				log.V(1).Info(m)
+				continue
+			}
+			if err != nil {
+				status.Conditions = append(status.Conditions, condition(v1alpha1.ClusterAddonActionOk, false, "DeleteFailed", err.Error()))
+				log.Error(err, "Delete")
+				r.recorder.Event(clusterAddon, corev1.EventTypeWarning, "DeleteFailed", fmt.Sprintf("Delete '%s' failed", n))
+				continue
+			}
+			m = fmt.Sprintf("Source '%s' removed", n)
+		}
+		r.recorder.Event(clusterAddon, corev1.EventTypeNormal, "Remove", m)
+		log.Info(m)
+		r.removeRepos(path.Join(clusterAddon.Namespace, clusterAddon.Name, n), "", log)
+		r.removeRunlog(path.Join(clusterAddon.Namespace, clusterAddon.Name, n), log)
+
+		delete(currentState.Sources, n)
 		hasStateChange = true
 	}
 
+	// Report what has been applied to the target cluster.
+	for n, ss := range status.Sources {
+		if cur, ok := currentState.Sources[n]; ok {
+			ss.AppliedSHA = cur.RepoSHA
+			ss.ActionHash = strconv.FormatUint(cur.ActionHash, 10)
+		}
+		status.Sources[n] = ss
+	}
+
 	status.Conditions = append(status.Conditions, condition(v1alpha1.ClusterAddonSynced, true, "", ""))
 
+	if forceToken != "" && !forcePending {
+		status.LastForceSync = forceToken
+		r.recorder.Event(clusterAddon, corev1.EventTypeNormal, "ForceSync", fmt.Sprintf("Force-sync %s handled", forceToken))
+	}
+
 	if !hasStateChange {
 		log.V(1).Info("Current state has not changed")
 		return status, nil
 	}
 
 	// When current state has changed it needs to written back to the target cluster.
-	// TODO state is only added to, old keys aren't removed.
 	err = putState(cl, currentState)
 	if err != nil {
 		// Performed Action but failed to write the new state.
@@ -242,9 +580,224 @@ func (r *ClusterAddonReconciler) createOrUpdate(
 }
 
 // Delete is the last call before the CR is deleted.
-func (r *ClusterAddonReconciler) delete(cl *cluster.Cluster, log logr.Logger) error {
-	//TODO add RECONCILE=DELETE ?
+// It tears down each source in the target cluster state, see teardown.
+// A source is removed from the target cluster state after its teardown succeeds.
+// Errors are mapped to status conditions, an error is returned when the delete needs to be retried.
+func (r *ClusterAddonReconciler) delete(
+	ctx context.Context,
+	cl *cluster.Cluster,
+	clusterAddon *v1alpha1.ClusterAddon,
+	log logr.Logger) (*v1alpha1.ClusterAddonStatus, error) {
+
 	log.V(1).Info("Delete")
+
+	status := &v1alpha1.ClusterAddonStatus{}
+
+	if !allowed(clusterAddon.Spec.Policy, opDelete) {
+		// Leave the cluster add-ons in place.
+		log.Info("Delete of cluster add-ons denied by policy", "policy", clusterAddon.Spec.Policy)
+		return status, nil
+	}
+	if clusterAddon.Spec.Mode == v1alpha1.ModePlan {
+		log.Info("Cluster add-ons are left in place in Plan mode")
+		return status, nil
+	}
+
+	if !cl.Ping() {
+		status.Conditions = append(status.Conditions, condition(v1alpha1.ClusterAddonTargetOk, false, "Ping", "Ping failed"))
+		return status, fmt.Errorf("ping %s failed", cl.Server)
+	}
+
+	currentState, err := getState(cl)
+	if err != nil {
+		status.Conditions = append(status.Conditions, condition(v1alpha1.ClusterAddonTargetOk, false, "Error", err.Error()))
+		return status, err
+	}
+	status.Conditions = append(status.Conditions, condition(v1alpha1.ClusterAddonTargetOk, true, "", ""))
+
+	var failed, pending []string
+	var hasStateChange bool
+	for n, cur := range currentState.Sources {
+		log := log.WithValues("source", n)
+
+		// Prefer the spec, fallback to the source stored with the state for sources that have been removed
+		// from the spec but not yet from the target cluster.
+		src := cur.Source
+		if s, ok := clusterAddon.Spec.Sources[n]; ok {
+			src = &s
+		}
+		if src == nil {
+			log.Info(fmt.Sprintf("Source '%s' has no teardown information, cluster add-ons are left in place", n))
+			delete(currentState.Sources, n)
+			hasStateChange = true
+			continue
+		}
+
+		err := r.teardown(ctx, cl, clusterAddon, n, src, cur.Inventory, log)
+		if errors.Is(err, errJobRunning) {
+			m := fmt.Sprintf("Delete Job of '%s' is running", n)
+			status.Conditions = append(status.Conditions, condition(v1alpha1.ClusterAddonSynced, false, "JobRunning", m))
+			log.V(1).Info(m)
+			pending = append(pending, n)
+			continue
+		}
+		if err != nil {
+			status.Conditions = append(status.Conditions, condition(v1alpha1.ClusterAddonActionOk, false, "DeleteFailed", err.Error()))
+			log.Error(err, "Delete")
+			r.recorder.Event(clusterAddon, corev1.EventTypeWarning, "DeleteFailed", fmt.Sprintf("Delete '%s' failed", n))
+			failed = append(failed, n)
+			continue
+		}
+
+		r.recorder.Event(clusterAddon, corev1.EventTypeNormal, "Delete", fmt.Sprintf("Delete '%s' successful", n))
+		log.Info(fmt.Sprintf("Delete '%s' successful", n))
+
+		delete(currentState.Sources, n)
+		hasStateChange = true
+	}
+
+	if hasStateChange {
+		err = putState(cl, currentState)
+		if err != nil {
+			return status, err
+		}
+	}
+
+	if len(failed) > 0 {
+		return status, fmt.Errorf("delete of %s failed", strings.Join(failed, ", "))
+	}
+	if len(pending) > 0 {
+		return status, fmt.Errorf("delete of %s pending: %w", strings.Join(pending, ", "), errJobRunning)
+	}
+
+	return status, nil
+}
+
+// RemoveFinalizer removes the finalizer from clusterAddon without tearing down its cluster add-ons because of err.
+// It's used when the target credentials are gone and the skip-teardown annotation is set.
+func (r *ClusterAddonReconciler) removeFinalizer(clusterAddon *v1alpha1.ClusterAddon, err error, log logr.Logger) error {
+	if !containsString(clusterAddon.ObjectMeta.Finalizers, finalizerName) {
+		return nil
+	}
+
+	m := fmt.Sprintf("Cluster add-ons are left in place, target credentials: %v", err)
+	r.recorder.Event(clusterAddon, corev1.EventTypeWarning, "DeleteSkipped", m)
+	log.Info(m)
+	r.removeRepos(path.Join(clusterAddon.Namespace, clusterAddon.Name), "", log)
+	r.removeRunlog(path.Join(clusterAddon.Namespace, clusterAddon.Name), log)
+
+	clusterAddon.ObjectMeta.Finalizers = removeString(clusterAddon.ObjectMeta.Finalizers, finalizerName)
+	err = r.Update(context.Background(), clusterAddon)
+	if err != nil {
+		return fmt.Errorf("finalizer remove: %w", err)
+	}
+	log.V(1).Info("Finalizer removed")
+
+	return nil
+}
+
+// SetSourceError sets the last error of source n in status.
+func setSourceError(status *v1alpha1.ClusterAddonStatus, n string, err error) {
+	ss := status.Sources[n]
+	ss.LastError = err.Error()
+	status.Sources[n] = ss
+}
+
+// Teardown runs the DeleteCmd of source n in the target cluster.
+// Without DeleteCmd the Helm release of a helm action is uninstalled and for other actions the objects in inventory
+// (the objects the source has applied) are deleted.
+func (r *ClusterAddonReconciler) teardown(ctx context.Context, cl *cluster.Cluster, clusterAddon *v1alpha1.ClusterAddon, n string, src *v1alpha1.ClusterAddonSource, inventory []cluster.ObjectRef, log logr.Logger) error {
+	namespace := clusterAddon.Namespace
+
+	if src.Action.DeleteCmd == "" {
+		if src.Action.Type == v1alpha1.RunTypeHelm {
+			start := time.Now()
+			ctx, cancel := context.WithTimeout(ctx, actionTimeout(&src.Action))
+			defer cancel()
+			out, err := uninstallChart(ctx, cl, &src.Action, n, log)
+			r.output(ctx, clusterAddon, n, src, start, out, log)
+			return err
+		}
+		return r.deleteInventory(ctx, cl, clusterAddon, n, src, inventory, log)
+	}
+
+	if src.Type == v1alpha1.SourceTypeInline && len(src.Files) == 0 {
+		// The files of an inline source aren't stored with the state, use the files the operator has.
+		if _, ok := r.Repos[path.Join(namespace, clusterAddon.Name, n, "files")]; !ok {
+			log.Info(fmt.Sprintf("Source '%s' has no files, cluster add-ons are left in place", n))
+			return nil
+		}
+	}
+
+	repo, err := r.repoFor(ctx, clusterAddon, n, src, log)
+	if err != nil {
+		return fmt.Errorf("get source: %w", err)
+	}
+
+	values, _, err := r.valuesFor(ctx, namespace, &src.Action)
+	if err != nil {
+		return err
+	}
+
+	if src.Action.Image != "" {
+		sha, err := repo.SHAlocal()
+		if err != nil {
+			return fmt.Errorf("get source: %w", err)
+		}
+		return r.teardownJob(ctx, cl, clusterAddon, src, &actionEnv{
+			source:   n,
+			dir:      repo.Dir(),
+			version:  repo.Version(),
+			revision: sha,
+			values:   values,
+			labels:   ownerLabels(clusterAddon, n),
+			target:   targetInfo{Name: clusterAddon.Name, Namespace: namespace, URL: cl.Server},
+			delete:   true,
+			log:      log,
+		})
+	}
+
+	env := []string{"REPODIR=" + repo.Dir(), "SOURCE_VERSION=" + repo.Version(), "RECONCILE=DELETE"}
+	start := time.Now()
+	timeout := actionTimeout(&src.Action)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	out, err := cl.RunShell(ctx, src.Action.DeleteCmd, values, env)
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = fmt.Errorf("timeout after %s: %w", timeout, err)
+	}
+	r.output(ctx, clusterAddon, n, src, start, out, log)
+	return err
+}
+
+// DeleteInventory deletes the objects in inventory that have been applied by source n from the target cluster.
+func (r *ClusterAddonReconciler) deleteInventory(ctx context.Context, cl *cluster.Cluster, clusterAddon *v1alpha1.ClusterAddon, n string, src *v1alpha1.ClusterAddonSource, inventory []cluster.ObjectRef, log logr.Logger) error {
+	if len(inventory) == 0 {
+		log.V(1).Info("No deleteCmd and no applied objects, cluster add-ons are left in place")
+		return nil
+	}
+
+	start := time.Now()
+	results, err := cl.Delete(inventory, ownerLabels(clusterAddon, n))
+	if err != nil {
+		return fmt.Errorf("delete: %w", err)
+	}
+
+	var out strings.Builder
+	var failed int
+	for _, res := range results {
+		if res.Err != nil {
+			fmt.Fprintf(&out, "%s delete failed %v\n", res.Ref, res.Err)
+			failed++
+			continue
+		}
+		fmt.Fprintf(&out, "%s deleted\n", res.Ref)
+	}
+	r.output(ctx, clusterAddon, n, src, start, out.String(), log)
+	if failed > 0 {
+		return fmt.Errorf("delete: %d of %d objects failed", failed, len(results))
+	}
+
 	return nil
 }
 
@@ -253,7 +806,8 @@ func calculateStatus(clusterAddon *v1alpha1.ClusterAddon, status *v1alpha1.Clust
 	// Steps:
 	// 1. Deduplicate status.Conditions
 	// 2. Merge status.Conditions into CR Status.
-	// 3. Update Status fields based on conditions.
+	// 3. Replace source status.
+	// 4. Update Status fields based on conditions.
 
 	// Create a status condition map with deduplicated status.Conditions
 	// The result is a logical AND of all instances of a type.
@@ -273,7 +827,13 @@ func calculateStatus(clusterAddon *v1alpha1.ClusterAddon, status *v1alpha1.Clust
 		if org.Reason == "" {
 			org.Reason = c.Reason
 		}
-		org.Message = org.Message + ", " + c.Message
+		switch {
+		case c.Message == "":
+		case org.Message == "":
+			org.Message = c.Message
+		default:
+			org.Message = org.Message + ", " + c.Message
+		}
 		scm[c.Type] = org
 	}
 
@@ -333,6 +893,18 @@ func calculateStatus(clusterAddon *v1alpha1.ClusterAddon, status *v1alpha1.Clust
 		}
 	}*/
 
+	// Replace the source status when it has been reported.
+	if status.Sources != nil && !equality.Semantic.DeepEqual(clusterAddon.Status.Sources, status.Sources) {
+		clusterAddon.Status.Sources = status.Sources
+		hasChanged = true
+	}
+
+	// Remember the force-sync request that has been handled.
+	if status.LastForceSync != "" && status.LastForceSync != clusterAddon.Status.LastForceSync {
+		clusterAddon.Status.LastForceSync = status.LastForceSync
+		hasChanged = true
+	}
+
 	// Copy Synced condition to status.synced.
 	for _, c := range clusterAddon.Status.Conditions {
 		if c.Type == v1alpha1.ClusterAddonSynced {
@@ -344,45 +916,235 @@ func calculateStatus(clusterAddon *v1alpha1.ClusterAddon, status *v1alpha1.Clust
 	return hasChanged
 }
 
-// ClusterFor get or creates a new cluster object for a ClusterAddonTarget.
-func (r *ClusterAddonReconciler) clusterFor(name string, target *v1alpha1.ClusterAddonTarget, log logr.Logger) (*cluster.Cluster, error) {
-	cl, err := cluster.New(name, log)
+// ClusterFor get or creates a new cluster object for the target of a ClusterAddon.
+// Credentials are read from the Secrets referred to by the target.
+func (r *ClusterAddonReconciler) clusterFor(ctx context.Context, clusterAddon *v1alpha1.ClusterAddon, log logr.Logger) (*cluster.Cluster, error) {
+	cl, err := cluster.New(clusterAddon.Namespace+"-"+clusterAddon.Name, log)
 	if err != nil {
 		return nil, err
 	}
 
-	err = cl.SetServerCoordinates(
-		target.URL,
-		target.CACert,
-		target.User,
-		target.Password,
-		target.ClientCert,
-		target.ClientKey)
+	target := &clusterAddon.Spec.Target
+
+	if ref := target.KubeconfigSecretRef; ref != nil {
+		kc, err := r.secretValue(ctx, clusterAddon.Namespace, ref)
+		if err != nil {
+			return nil, err
+		}
+		return cl, cl.SetKubeconfig(kc)
+	}
+
+	caCert, user, password, clientCert, clientKey := target.CACert, target.User, target.Password, target.ClientCert, target.ClientKey
+	if ref := target.SecretRef; ref != nil {
+		secret := &corev1.Secret{}
+		err := r.Get(ctx, types.NamespacedName{Namespace: clusterAddon.Namespace, Name: ref.Name}, secret)
+		if err != nil {
+			return nil, err
+		}
+		if v, ok := secret.Data["caCert"]; ok {
+			caCert = v
+		}
+		if v, ok := secret.Data["user"]; ok {
+			user = string(v)
+		}
+		if v, ok := secret.Data["password"]; ok {
+			password = string(v)
+		}
+		if v, ok := secret.Data["clientCert"]; ok {
+			clientCert = v
+		}
+		if v, ok := secret.Data["clientKey"]; ok {
+			clientKey = v
+		}
+	}
+
+	err = cl.SetServerCoordinates(target.URL, caCert, user, password, clientCert, clientKey)
 
 	return cl, err
 }
 
-// RepoFor gets or creates a new Repo object from a ClusterAddon.spec.source item.
-func (r *ClusterAddonReconciler) repoFor(src *v1alpha1.ClusterAddonSource, log logr.Logger) (*repogit.Repo, error) {
-	log.V(1).Info("Get repo", "url", src.URL, "branch", src.Branch)
+// SecretValue returns the value of a key in a Secret in namespace.
+func (r *ClusterAddonReconciler) secretValue(ctx context.Context, namespace string, ref *v1alpha1.SecretKeyReference) ([]byte, error) {
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Namespace: namespace, Name: ref.Name}, secret)
+	if err != nil {
+		return nil, err
+	}
 
-	name := repogit.Hashed(src.URL, src.Branch)
+	v, ok := secret.Data[ref.Key]
+	if !ok {
+		return nil, fmt.Errorf("secret %s has no key %s", ref.Name, ref.Key)
+	}
 
-	if re, ok := r.Repos[name]; ok {
-		return re, nil
+	return v, nil
+}
+
+// RepoFor gets or creates a Repo object for a ClusterAddon.spec.source item n and updates it to the latest commit.
+// The token is read from the Secret referred to by the source.
+func (r *ClusterAddonReconciler) repoFor(ctx context.Context, clusterAddon *v1alpha1.ClusterAddon, n string, src *v1alpha1.ClusterAddonSource, log logr.Logger) (Repo, error) {
+	log.V(1).Info("Get repo", "type", src.Type, "url", src.URL, "branch", src.Branch, "revision", src.Revision, "tag", src.Tag, "semverRange", src.SemverRange)
+	namespace := clusterAddon.Namespace
+
+	switch src.Type {
+	case v1alpha1.SourceTypeConfigMap, v1alpha1.SourceTypeInline:
+		return r.filesRepoFor(ctx, clusterAddon, n, src, log)
+	}
+	if src.URL == "" {
+		return nil, fmt.Errorf("source requires a url")
 	}
 
-	re, err := repogit.New(src.URL, src.Branch, src.Token, log)
+	token, err := r.tokenFor(ctx, namespace, src)
 	if err != nil {
 		return nil, err
 	}
-	r.Repos[name] = re
 
-	err = re.Update()
+	// The repo is specific to the source of the ClusterAddon.
+	owner := path.Join(namespace, clusterAddon.Name, n)
+	var name string
+	versioned := src.Tag != "" || src.SemverRange != ""
+	switch src.Type {
+	case v1alpha1.SourceTypeGIT, "":
+		switch {
+		case versioned && (src.Branch != "" || src.Revision != ""):
+			return nil, fmt.Errorf("source type git requires either a branch (and revision) or a tag/semverRange")
+		case versioned:
+			name = "git/" + repogit.Hashed(src.URL, repogit.VersionRef(src.Tag, src.SemverRange))
+		case src.Branch == "":
+			return nil, fmt.Errorf("source type git requires a branch, tag or semverRange")
+		case src.Revision != "":
+			name = "git/" + repogit.Hashed(src.URL, repogit.RevisionRef(src.Branch, src.Revision))
+		default:
+			name = "git/" + repogit.Hashed(src.URL, src.Branch)
+		}
+	case v1alpha1.SourceTypeOCI:
+		name = "oci/" + repooci.Hashed(src.URL, src.Tag)
+	case v1alpha1.SourceTypeHTTP:
+		name = "http/" + repohttp.Hashed(src.URL, src.SHA256)
+	default:
+		return nil, fmt.Errorf("source type %s not supported", src.Type)
+	}
+	name = path.Join(owner, name)
+
+	re, ok := r.Repos[name]
+	if ok {
+		// Token rotation.
+		err := re.SetToken(token)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		switch src.Type {
+		case v1alpha1.SourceTypeOCI:
+			re, err = repooci.New(owner, src.URL, src.Tag, token, log)
+		case v1alpha1.SourceTypeHTTP:
+			re, err = repohttp.New(owner, src.URL, src.SHA256, token, log)
+		default:
+			switch {
+			case versioned:
+				re, err = repogit.NewVersion(owner, src.URL, src.Tag, src.SemverRange, token, log)
+			case src.Revision != "":
+				re, err = repogit.NewRevision(owner, src.URL, src.Branch, src.Revision, token, log)
+			default:
+				re, err = repogit.New(owner, src.URL, src.Branch, token, log)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		// The source has changed (for example another tag), the previous repo is no longer used.
+		r.removeRepos(owner, name, log)
+		r.Repos[name] = re
+	}
+
+	uctx, cancel := context.WithTimeout(ctx, RepoUpdateTimeout)
+	defer cancel()
+	err = re.Update(uctx)
+
+	return re, err
+}
+
+// TokenFor returns the token to access the remote server of src.
+func (r *ClusterAddonReconciler) tokenFor(ctx context.Context, namespace string, src *v1alpha1.ClusterAddonSource) (string, error) {
+	if ref := src.TokenSecretRef; ref != nil {
+		b, err := r.secretValue(ctx, namespace, ref)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+	return src.Token, nil
+}
+
+// FilesRepoFor gets or creates a Repo object for a ClusterAddon.spec.source item n of type configmap or inline and
+// updates it to the current files.
+func (r *ClusterAddonReconciler) filesRepoFor(ctx context.Context, clusterAddon *v1alpha1.ClusterAddon, n string, src *v1alpha1.ClusterAddonSource, log logr.Logger) (Repo, error) {
+	files := map[string][]byte{}
+	switch src.Type {
+	case v1alpha1.SourceTypeConfigMap:
+		if src.ConfigMapRef == nil {
+			return nil, fmt.Errorf("source type configmap requires a configMapRef")
+		}
+		cm := &corev1.ConfigMap{}
+		err := r.Get(ctx, types.NamespacedName{Namespace: clusterAddon.Namespace, Name: src.ConfigMapRef.Name}, cm)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range cm.Data {
+			files[k] = []byte(v)
+		}
+		for k, v := range cm.BinaryData {
+			files[k] = v
+		}
+	case v1alpha1.SourceTypeInline:
+		for k, v := range src.Files {
+			files[k] = []byte(v)
+		}
+	}
+	// The files are specific to the source of the ClusterAddon.
+	owner := path.Join(clusterAddon.Namespace, clusterAddon.Name, n)
+	name := path.Join(owner, "files")
+	re, ok := r.Repos[name].(*repofiles.Repo)
+	if len(files) == 0 {
+		if ok && src.Type == v1alpha1.SourceTypeInline {
+			// Teardown of a removed source, its files aren't stored with the state.
+			return re, nil
+		}
+		return nil, fmt.Errorf("source type %s has no files", src.Type)
+	}
+	if !ok {
+		var err error
+		re, err = repofiles.New(owner, log)
+		if err != nil {
+			return nil, err
+		}
+		r.removeRepos(owner, name, log)
+		r.Repos[name] = re
+	}
+
+	err := re.SetFiles(files)
+	if err != nil {
+		return nil, err
+	}
+	err = re.Update(ctx)
 
 	return re, err
 }
 
+// RemoveRepos removes the repos of owner (namespace/clusteraddon or namespace/clusteraddon/source) except keep.
+func (r *ClusterAddonReconciler) removeRepos(owner, keep string, log logr.Logger) {
+	for name, re := range r.Repos {
+		if name == keep || !strings.HasPrefix(name, owner+"/") {
+			continue
+		}
+		err := re.Remove()
+		if err != nil {
+			log.Error(err, "Remove repo", "repo", name)
+		}
+		delete(r.Repos, name)
+	}
+}
+
 // IgnoreNotFound makes NotFound errors disappear.
 // We generally want to ignore (not requeue) NotFound errors, since we'll get a
 // reconciliation request once the object exists, and requeuing in the meantime
@@ -397,12 +1159,43 @@ func ignoreNotFound(err error) error {
 // SetupWithManager initializes the receiver and adds it to mgr.
 func (r *ClusterAddonReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	if r.Repos == nil {
-		r.Repos = make(map[string]*repogit.Repo)
+		r.Repos = make(map[string]Repo)
+	}
+	if r.Runlog == nil {
+		r.Runlog = &runlog.Runlog{Dir: filepath.Join(os.TempDir(), "runlog"), Keep: 5}
+	}
+
+	if r.podLogs == nil {
+		cs, err := kubernetes.NewForConfig(mgr.GetConfig())
+		if err != nil {
+			return err
+		}
+		r.podLogs = func(namespace, name string) ([]byte, error) {
+			return cs.CoreV1().Pods(namespace).GetLogs(name, &corev1.PodLogOptions{}).DoRaw()
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.ctx = ctx
+	err := mgr.Add(manager.RunnableFunc(func(stop <-chan struct{}) error {
+		<-stop
+		cancel()
+		return nil
+	}))
+	if err != nil {
+		return err
 	}
 
 	r.recorder = mgr.GetEventRecorderFor("op-addons") //TODO use same name for metrics
 
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&v1alpha1.ClusterAddon{}).
+		Owns(&batchv1.Job{}).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: r.requestsFor(secretNames),
+		}).
+		Watches(&source.Kind{Type: &corev1.ConfigMap{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: r.requestsFor(configMapNames),
+		}).
 		Complete(r)
 }
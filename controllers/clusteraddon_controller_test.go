@@ -1,9 +1,29 @@
 package controllers
 
 import (
+	tarpkg "archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
 	"github.com/google/go-cmp/cmp"
 	"github.com/mmlt/operator-addons/api/v1alpha1"
+	"github.com/mmlt/operator-addons/internal/cluster"
+	"github.com/mmlt/operator-addons/internal/runlog"
+	"io/ioutil"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -374,6 +394,53 @@ func Test_updateStatus(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "sources_are_replaced",
+			args: args{
+				clusterAddon: &v1alpha1.ClusterAddon{
+					Status: v1alpha1.ClusterAddonStatus{
+						Sources: map[string]v1alpha1.SourceStatus{
+							"a": {AppliedSHA: "1", LastSuccessTime: &metav1.Time{Time: time1}},
+							"b": {AppliedSHA: "1"},
+						},
+					},
+				},
+				status: &v1alpha1.ClusterAddonStatus{
+					Sources: map[string]v1alpha1.SourceStatus{
+						"a": {AppliedSHA: "2", LastSuccessTime: &metav1.Time{Time: time2}},
+					},
+				},
+			},
+			want: true,
+			wantCA: &v1alpha1.ClusterAddon{
+				Status: v1alpha1.ClusterAddonStatus{
+					Sources: map[string]v1alpha1.SourceStatus{
+						"a": {AppliedSHA: "2", LastSuccessTime: &metav1.Time{Time: time2}},
+					},
+				},
+			},
+		},
+		{
+			name: "sources_are_kept_when_not_reported",
+			args: args{
+				clusterAddon: &v1alpha1.ClusterAddon{
+					Status: v1alpha1.ClusterAddonStatus{
+						Sources: map[string]v1alpha1.SourceStatus{
+							"a": {AppliedSHA: "1"},
+						},
+					},
+				},
+				status: &v1alpha1.ClusterAddonStatus{},
+			},
+			want: false,
+			wantCA: &v1alpha1.ClusterAddon{
+				Status: v1alpha1.ClusterAddonStatus{
+					Sources: map[string]v1alpha1.SourceStatus{
+						"a": {AppliedSHA: "1"},
+					},
+				},
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -388,3 +455,540 @@ func Test_updateStatus(t *testing.T) {
 		})
 	}
 }
+
+func Test_clusterFor(t *testing.T) {
+	kubeconfig := `
+apiVersion: v1
+kind: Config
+clusters:
+- name: workload
+  cluster:
+    server: https://workload.example.com:6443
+users:
+- name: admin
+  user:
+    token: abc
+contexts:
+- name: admin@workload
+  context:
+    cluster: workload
+    user: admin
+current-context: admin@workload
+`
+	r := testReconciler(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "creds"},
+			Data: map[string][]byte{
+				"user":     []byte("admin"),
+				"password": []byte("from-secret"),
+			},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "workload-kubeconfig"},
+			Data: map[string][]byte{
+				"value": []byte(kubeconfig),
+			},
+		},
+	)
+
+	tests := []struct {
+		name         string
+		target       v1alpha1.ClusterAddonTarget
+		wantServer   string
+		wantInConfig string
+		wantErr      bool
+	}{
+		{
+			name: "inline",
+			target: v1alpha1.ClusterAddonTarget{
+				URL:      "https://inline.example.com",
+				User:     "admin",
+				Password: "inline",
+			},
+			wantServer:   "https://inline.example.com",
+			wantInConfig: "password: inline",
+		},
+		{
+			name: "secretRef",
+			target: v1alpha1.ClusterAddonTarget{
+				URL:       "https://inline.example.com",
+				Password:  "inline",
+				SecretRef: &corev1.LocalObjectReference{Name: "creds"},
+			},
+			wantServer:   "https://inline.example.com",
+			wantInConfig: "password: from-secret",
+		},
+		{
+			name: "kubeconfigSecretRef",
+			target: v1alpha1.ClusterAddonTarget{
+				URL:                 "https://inline.example.com",
+				KubeconfigSecretRef: &v1alpha1.SecretKeyReference{Name: "workload-kubeconfig", Key: "value"},
+			},
+			wantServer:   "https://workload.example.com:6443",
+			wantInConfig: "token: abc",
+		},
+		{
+			name: "missing_secret",
+			target: v1alpha1.ClusterAddonTarget{
+				SecretRef: &corev1.LocalObjectReference{Name: "missing"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "missing_key",
+			target: v1alpha1.ClusterAddonTarget{
+				KubeconfigSecretRef: &v1alpha1.SecretKeyReference{Name: "workload-kubeconfig", Key: "missing"},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ca := &v1alpha1.ClusterAddon{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "test-clusterfor-" + tt.name},
+				Spec:       v1alpha1.ClusterAddonSpec{Target: tt.target},
+			}
+			cl, err := r.clusterFor(context.Background(), ca, testLogger())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer os.RemoveAll(cl.Path)
+
+			if cl.Server != tt.wantServer {
+				t.Errorf("got server %q, want %q", cl.Server, tt.wantServer)
+			}
+			b, err := ioutil.ReadFile(filepath.Join(cl.Path, ".kube", "config"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(b), tt.wantInConfig) {
+				t.Errorf("kubeconfig does not contain %q:\n%s", tt.wantInConfig, b)
+			}
+		})
+	}
+}
+
+func Test_reconcile_suspended(t *testing.T) {
+	now := metav1.Now()
+	r := testReconciler(
+		&v1alpha1.ClusterAddon{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "suspended"},
+			Spec:       v1alpha1.ClusterAddonSpec{Suspend: true},
+		},
+		&v1alpha1.ClusterAddon{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "deleted", DeletionTimestamp: &now,
+				Finalizers: []string{finalizerName}},
+			Spec: v1alpha1.ClusterAddonSpec{Suspend: true},
+		},
+	)
+	ctx := context.Background()
+
+	res, err := r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "ns", Name: "suspended"}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.RequeueAfter != 0 {
+		t.Errorf("got requeue after %s, want none", res.RequeueAfter)
+	}
+	ca := &v1alpha1.ClusterAddon{}
+	_ = r.Get(ctx, types.NamespacedName{Namespace: "ns", Name: "suspended"}, ca)
+	if len(ca.Status.Conditions) != 1 || ca.Status.Conditions[0].Type != v1alpha1.ClusterAddonSuspended ||
+		ca.Status.Conditions[0].Status != metav1.ConditionTrue {
+		t.Errorf("got conditions %v, want Suspended", ca.Status.Conditions)
+	}
+	if len(ca.Finalizers) != 0 {
+		t.Errorf("got finalizers %v, want none", ca.Finalizers)
+	}
+
+	_, err = r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "ns", Name: "deleted"}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca = &v1alpha1.ClusterAddon{}
+	_ = r.Get(ctx, types.NamespacedName{Namespace: "ns", Name: "deleted"}, ca)
+	if len(ca.Finalizers) != 0 {
+		t.Errorf("got finalizers %v, want none", ca.Finalizers)
+	}
+}
+
+func Test_repoFor_configmap(t *testing.T) {
+	ca := &v1alpha1.ClusterAddon{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "ca"}}
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "content"},
+		Data:       map[string]string{"policy.yaml": "kind: NetworkPolicy"},
+	}
+	r := testReconciler(ca, cm)
+	r.Repos = map[string]Repo{}
+	src := &v1alpha1.ClusterAddonSource{Type: v1alpha1.SourceTypeConfigMap, ConfigMapRef: &corev1.LocalObjectReference{Name: "content"}}
+	ctx := context.Background()
+
+	repo, err := r.repoFor(ctx, ca, "base", src, testLogger())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(filepath.Dir(repo.Dir()))
+	b, err := ioutil.ReadFile(filepath.Join(repo.Dir(), "policy.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "kind: NetworkPolicy" {
+		t.Errorf("got %q, want kind: NetworkPolicy", b)
+	}
+	sha1, _ := repo.SHAlocal()
+
+	// Change the ConfigMap.
+	cm.Data["policy.yaml"] = "kind: NetworkPolicy\nmetadata: {}"
+	if err := r.Update(ctx, cm); err != nil {
+		t.Fatal(err)
+	}
+
+	repo, err = r.repoFor(ctx, ca, "base", src, testLogger())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sha2, _ := repo.SHAlocal(); sha1 == sha2 {
+		t.Errorf("revision %s doesn't change with the ConfigMap content", sha1)
+	}
+}
+
+func Test_repoFor_git_requires_one_ref(t *testing.T) {
+	ca := &v1alpha1.ClusterAddon{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "ca"}}
+	r := testReconciler(ca)
+	r.Repos = map[string]Repo{}
+	url := "https://example.com/addons.git"
+
+	for _, src := range []v1alpha1.ClusterAddonSource{
+		{URL: url},
+		{URL: url, Revision: "a3a053fb"},
+		{URL: url, Branch: "master", Tag: "v1.0.0"},
+		{URL: url, Tag: "v1.0.0", Revision: "a3a053fb"},
+	} {
+		_, err := r.repoFor(context.Background(), ca, "base", &src, testLogger())
+
+		if err == nil {
+			t.Errorf("expected error for %+v", src)
+		}
+	}
+	if len(r.Repos) != 0 {
+		t.Errorf("got %d repos, want none", len(r.Repos))
+	}
+}
+
+func Test_repoFor_per_clusteraddon(t *testing.T) {
+	// An archive server that only serves the files of the ClusterAddon with token "a".
+	var tar bytes.Buffer
+	tw := tarpkg.NewWriter(&tar)
+	_ = tw.WriteHeader(&tarpkg.Header{Name: "policy.yaml", Mode: 0644, Size: 4})
+	_, _ = tw.Write([]byte("kind"))
+	_ = tw.Close()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer a" {
+			http.Error(w, "denied", http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write(tar.Bytes())
+	}))
+	defer ts.Close()
+
+	caA := &v1alpha1.ClusterAddon{ObjectMeta: metav1.ObjectMeta{Namespace: "ns-a", Name: "ca"}}
+	caB := &v1alpha1.ClusterAddon{ObjectMeta: metav1.ObjectMeta{Namespace: "ns-b", Name: "ca"}}
+	r := testReconciler(caA, caB)
+	r.Repos = map[string]Repo{}
+	defer r.removeRepos("ns-a", "", testLogger())
+	defer r.removeRepos("ns-b", "", testLogger())
+	ctx := context.Background()
+
+	a, err := r.repoFor(ctx, caA, "base", &v1alpha1.ClusterAddonSource{Type: v1alpha1.SourceTypeHTTP, URL: ts.URL + "/addon.tar", Token: "a"}, testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := r.repoFor(ctx, caB, "base", &v1alpha1.ClusterAddonSource{Type: v1alpha1.SourceTypeHTTP, URL: ts.URL + "/addon.tar", Token: "b"}, testLogger())
+
+	if err == nil {
+		t.Error("expected ClusterAddon with token b to be denied")
+	}
+	if a.Dir() == b.Dir() {
+		t.Errorf("ClusterAddons share dir %s", a.Dir())
+	}
+	if _, err := os.Stat(filepath.Join(b.Dir(), "policy.yaml")); err == nil {
+		t.Error("ClusterAddon with token b got the files of ClusterAddon with token a")
+	}
+
+	// Removing the ClusterAddon removes its repos.
+	r.removeRepos("ns-a/ca", "", testLogger())
+	if _, err := os.Stat(a.Dir()); !os.IsNotExist(err) {
+		t.Errorf("got %v, want dir %s removed", err, a.Dir())
+	}
+	if len(r.Repos) != 1 {
+		t.Errorf("got %d repos, want 1", len(r.Repos))
+	}
+}
+
+// TestTarget is a fake target cluster API Server that keeps core/v1 ConfigMaps and Secrets in memory.
+type testTarget struct {
+	*httptest.Server
+	mu sync.Mutex
+	// Objects by namespace/resource/name.
+	objects map[string][]byte
+	// Writes are the create, update and delete requests that have been received.
+	writes []string
+}
+
+// NewTestTarget starts a fake target cluster.
+// Call Close when done.
+func newTestTarget() *testTarget {
+	tt := &testTarget{objects: map[string][]byte{}}
+	tt.Server = httptest.NewServer(http.HandlerFunc(tt.serve))
+	return tt
+}
+
+// Put stores obj as resource (for example configmaps) in the fake target.
+func (tt *testTarget) put(t *testing.T, resource string, obj metav1.Object) {
+	m, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m["apiVersion"] = "v1"
+	m["kind"] = map[string]string{"configmaps": "ConfigMap", "secrets": "Secret"}[resource]
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	tt.objects[path.Join(obj.GetNamespace(), resource, obj.GetName())] = b
+}
+
+// Cluster returns a Cluster that accesses the fake target.
+func (tt *testTarget) cluster(t *testing.T) *cluster.Cluster {
+	cl, err := cluster.New("test-target", testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cl.SetServerCoordinates(tt.URL, nil, "test", "test", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cl
+}
+
+// Exists returns true when the object namespace/resource/name exists.
+func (tt *testTarget) exists(namespace, resource, name string) bool {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	_, ok := tt.objects[path.Join(namespace, resource, name)]
+	return ok
+}
+
+func (tt *testTarget) serve(w http.ResponseWriter, req *http.Request) {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	switch req.URL.Path {
+	case "/":
+		_, _ = w.Write([]byte(`{}`))
+		return
+	case "/api":
+		_, _ = w.Write([]byte(`{"kind":"APIVersions","versions":["v1"],"serverAddressByClientCIDRs":[{"clientCIDR":"0.0.0.0/0","serverAddress":"localhost"}]}`))
+		return
+	case "/apis":
+		_, _ = w.Write([]byte(`{"kind":"APIGroupList","apiVersion":"v1","groups":[]}`))
+		return
+	case "/api/v1":
+		_, _ = w.Write([]byte(`{"kind":"APIResourceList","groupVersion":"v1","resources":[` +
+			`{"name":"configmaps","namespaced":true,"kind":"ConfigMap","verbs":["create","delete","get","list","patch","update"]},` +
+			`{"name":"secrets","namespaced":true,"kind":"Secret","verbs":["create","delete","get","list","patch","update"]}]}`))
+		return
+	}
+
+	// /api/v1/namespaces/<namespace>/<resource>[/<name>]
+	ss := strings.Split(strings.TrimPrefix(req.URL.Path, "/api/v1/namespaces/"), "/")
+	if len(ss) < 2 || !strings.HasPrefix(req.URL.Path, "/api/v1/namespaces/") {
+		http.NotFound(w, req)
+		return
+	}
+	body, _ := ioutil.ReadAll(req.Body)
+	if req.Method == http.MethodPost {
+		var m metav1.PartialObjectMetadata
+		_ = json.Unmarshal(body, &m)
+		ss = append(ss[:2], m.Name)
+	}
+	if len(ss) != 3 {
+		http.NotFound(w, req)
+		return
+	}
+	key := path.Join(ss...)
+
+	switch req.Method {
+	case http.MethodGet:
+		b, ok := tt.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"NotFound","code":404,"details":{"name":%q,"kind":%q}}`, ss[2], ss[1])
+			return
+		}
+		_, _ = w.Write(b)
+	case http.MethodPost, http.MethodPut:
+		tt.objects[key] = body
+		tt.writes = append(tt.writes, req.Method+" "+key)
+		_, _ = w.Write(body)
+	case http.MethodDelete:
+		delete(tt.objects, key)
+		tt.writes = append(tt.writes, req.Method+" "+key)
+		_, _ = w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Success"}`))
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func Test_teardown_deletes_inventory(t *testing.T) {
+	target := newTestTarget()
+	defer target.Close()
+	ca := &v1alpha1.ClusterAddon{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "ca"}}
+	target.put(t, "configmaps", &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "a", Labels: ownerLabels(ca, "base")}})
+	r := testReconciler(ca)
+	dir, err := ioutil.TempDir("", "runlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	r.Runlog = &runlog.Runlog{Dir: dir, Keep: 1}
+	src := &v1alpha1.ClusterAddonSource{Action: v1alpha1.ClusterAddonAction{Type: v1alpha1.RunTypeManifests}}
+	inventory := []cluster.ObjectRef{{APIVersion: "v1", Kind: "ConfigMap", Namespace: "default", Name: "a"}}
+
+	err = r.teardown(context.Background(), target.cluster(t), ca, "base", src, inventory, testLogger())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target.exists("default", "configmaps", "a") {
+		t.Error("expected ConfigMap in inventory to be deleted")
+	}
+}
+
+func Test_teardown_uninstalls_helm_release(t *testing.T) {
+	target := newTestTarget()
+	defer target.Close()
+	ca := &v1alpha1.ClusterAddon{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "ca"}}
+	r := testReconciler(ca)
+	dir, err := ioutil.TempDir("", "helm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	r.Runlog = &runlog.Runlog{Dir: dir, Keep: 1}
+
+	// A fake helm that lists the release and records the uninstall.
+	calls := filepath.Join(dir, "calls")
+	script := `#!/bin/sh
+echo "$@" >> ` + calls + `
+if [ "$1" = "list" ]; then
+  echo '[{"name":"ingress","namespace":"infra","revision":"1","status":"deployed"}]'
+fi
+`
+	err = ioutil.WriteFile(filepath.Join(dir, "helm"), []byte(script), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	src := &v1alpha1.ClusterAddonSource{Action: v1alpha1.ClusterAddonAction{Type: v1alpha1.RunTypeHelm, Release: "ingress", Namespace: "infra"}}
+
+	err = r.teardown(context.Background(), target.cluster(t), ca, "base", src, nil, testLogger())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "uninstall ingress --namespace infra\n") {
+		t.Errorf("got helm calls %q, want uninstall of ingress", b)
+	}
+}
+
+func Test_reconcile_deleted_without_credentials(t *testing.T) {
+	now := metav1.Now()
+	r := testReconciler(
+		&v1alpha1.ClusterAddon{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "deleted", DeletionTimestamp: &now,
+				Finalizers: []string{finalizerName}},
+			Spec: v1alpha1.ClusterAddonSpec{Target: v1alpha1.ClusterAddonTarget{
+				SecretRef: &corev1.LocalObjectReference{Name: "missing"},
+			}},
+		},
+	)
+	recorder := record.NewFakeRecorder(10)
+	r.recorder = recorder
+
+	_, err := r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "ns", Name: "deleted"}})
+
+	if err == nil {
+		t.Error("expected error")
+	}
+	ca := &v1alpha1.ClusterAddon{}
+	_ = r.Get(context.Background(), types.NamespacedName{Namespace: "ns", Name: "deleted"}, ca)
+	if len(ca.Finalizers) != 1 {
+		t.Errorf("got finalizers %v, want %s", ca.Finalizers, finalizerName)
+	}
+	if len(ca.Status.Conditions) != 1 || ca.Status.Conditions[0].Type != v1alpha1.ClusterAddonTargetOk ||
+		ca.Status.Conditions[0].Status != metav1.ConditionFalse {
+		t.Errorf("got conditions %v, want TargetOk False", ca.Status.Conditions)
+	}
+	if e := <-recorder.Events; !strings.Contains(e, "DeleteBlocked") {
+		t.Errorf("got event %q, want DeleteBlocked", e)
+	}
+}
+
+func Test_reconcile_deleted_without_credentials_skip_teardown(t *testing.T) {
+	now := metav1.Now()
+	r := testReconciler(
+		&v1alpha1.ClusterAddon{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "deleted", DeletionTimestamp: &now,
+				Finalizers:  []string{finalizerName},
+				Annotations: map[string]string{annotationSkipTeardown: "true"}},
+			Spec: v1alpha1.ClusterAddonSpec{Target: v1alpha1.ClusterAddonTarget{
+				SecretRef: &corev1.LocalObjectReference{Name: "missing"},
+			}},
+		},
+	)
+	recorder := record.NewFakeRecorder(10)
+	r.recorder = recorder
+	r.Repos = map[string]Repo{}
+	dir, err := ioutil.TempDir("", "runlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	r.Runlog = &runlog.Runlog{Dir: dir, Keep: 1}
+	_, err = r.Runlog.Write("ns/deleted/base", time.Now(), "output")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "ns", Name: "deleted"}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := &v1alpha1.ClusterAddon{}
+	_ = r.Get(context.Background(), types.NamespacedName{Namespace: "ns", Name: "deleted"}, ca)
+	if len(ca.Finalizers) != 0 {
+		t.Errorf("got finalizers %v, want none", ca.Finalizers)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ns", "deleted")); !os.IsNotExist(err) {
+		t.Errorf("expected run logs to be removed, got %v", err)
+	}
+	if e := <-recorder.Events; !strings.Contains(e, "DeleteSkipped") {
+		t.Errorf("got event %q, want DeleteSkipped", e)
+	}
+}
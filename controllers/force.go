@@ -0,0 +1,40 @@
+package controllers
+
+import (
+	"strings"
+
+	v1alpha1 "github.com/mmlt/operator-addons/api/v1alpha1"
+)
+
+// Annotations to force actions to run although nothing has changed.
+const (
+	// AnnotationForceSync runs the actions once for each new value, for example a timestamp.
+	annotationForceSync = "clusterops.mmlt.nl/force-sync"
+	// AnnotationForceSyncSources limits force-sync to a comma separated list of source names.
+	annotationForceSyncSources = "clusterops.mmlt.nl/force-sync-sources"
+)
+
+// ForceSync returns the value of a force-sync request that hasn't been handled yet and the names of the sources it
+// applies to (nil means all sources).
+// An empty value means there is no request.
+func forceSync(clusterAddon *v1alpha1.ClusterAddon) (string, map[string]bool) {
+	token := clusterAddon.Annotations[annotationForceSync]
+	if token == "" || token == clusterAddon.Status.LastForceSync {
+		return "", nil
+	}
+
+	s := strings.TrimSpace(clusterAddon.Annotations[annotationForceSyncSources])
+	if s == "" {
+		return token, nil
+	}
+	sources := map[string]bool{}
+	for _, n := range strings.Split(s, ",") {
+		sources[strings.TrimSpace(n)] = true
+	}
+	return token, sources
+}
+
+// Forced returns true when the force-sync request with token applies to source n.
+func forced(token string, sources map[string]bool, n string) bool {
+	return token != "" && (sources == nil || sources[n])
+}
@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mmlt/operator-addons/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_forceSync(t *testing.T) {
+	tests := []struct {
+		it          string
+		annotations map[string]string
+		last        string
+		wantToken   string
+		wantSources map[string]bool
+	}{
+		{it: "has_no_request"},
+		{
+			it:          "forces_all_sources",
+			annotations: map[string]string{annotationForceSync: "1"},
+			wantToken:   "1",
+		},
+		{
+			it:          "forces_some_sources",
+			annotations: map[string]string{annotationForceSync: "2", annotationForceSyncSources: "a, b"},
+			last:        "1",
+			wantToken:   "2",
+			wantSources: map[string]bool{"a": true, "b": true},
+		},
+		{
+			it:          "ignores_a_handled_request",
+			annotations: map[string]string{annotationForceSync: "1"},
+			last:        "1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.it, func(t *testing.T) {
+			ca := &v1alpha1.ClusterAddon{
+				ObjectMeta: metav1.ObjectMeta{Annotations: tt.annotations},
+				Status:     v1alpha1.ClusterAddonStatus{LastForceSync: tt.last},
+			}
+
+			token, sources := forceSync(ca)
+
+			if token != tt.wantToken {
+				t.Errorf("got token %q, want %q", token, tt.wantToken)
+			}
+			if diff := cmp.Diff(sources, tt.wantSources); diff != "" {
+				t.Errorf("diff (- = got, + = want) %s", diff)
+			}
+		})
+	}
+}
+
+func Test_forced(t *testing.T) {
+	if forced("", nil, "a") {
+		t.Error("forced without token")
+	}
+	if !forced("1", nil, "a") {
+		t.Error("not forced with token for all sources")
+	}
+	if forced("1", map[string]bool{"b": true}, "a") {
+		t.Error("forced source that hasn't been named")
+	}
+}
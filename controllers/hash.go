@@ -0,0 +1,51 @@
+package controllers
+
+import (
+	"reflect"
+
+	"github.com/mitchellh/hashstructure"
+	v1alpha1 "github.com/mmlt/operator-addons/api/v1alpha1"
+)
+
+// ActionHash returns a hash of the action with values.
+// Values are the merged values so a change of a referred ConfigMap or Secret changes the hash.
+func actionHash(action *v1alpha1.ClusterAddonAction, values map[string]interface{}) (uint64, error) {
+	return hashstructure.Hash(ClusterAddonAction{
+		Type:      action.Type,
+		Cmd:       action.Cmd,
+		Values:    values,
+		Path:      action.Path,
+		Release:   action.Release,
+		Namespace: action.Namespace,
+		Image:     action.Image,
+		Render:    action.Render,
+	}, nil)
+}
+
+// ClusterAddonAction contains the fields of v1alpha1.ClusterAddonAction that change the action hash.
+//
+// A changed hash re-runs the action in all target clusters so the hash of existing actions must not change when
+// fields are added to the API. Therefore the first fields are hashed as they were in the first release and
+// fields that are added later are only hashed when they are set.
+// hashstructure includes the type name (but not the package) in the hash, so don't rename this type.
+type ClusterAddonAction struct {
+	Type   v1alpha1.ClusterAddonActionType
+	Cmd    string
+	Values map[string]interface{}
+
+	// Fields added after the first release.
+	Path      string
+	Release   string
+	Namespace string
+	Image     string
+	Render    bool
+}
+
+// HashInclude implements hashstructure.Includable.
+func (a ClusterAddonAction) HashInclude(field string, v interface{}) (bool, error) {
+	switch field {
+	case "Type", "Cmd", "Values":
+		return true, nil
+	}
+	return !v.(reflect.Value).IsZero(), nil
+}
@@ -0,0 +1,64 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/mitchellh/hashstructure"
+	"github.com/mmlt/operator-addons/api/v1alpha1"
+)
+
+// Test_action_hash_is_stable checks that adding fields to ClusterAddonAction or changing the type of Values doesn't
+// change the hash of existing actions, a changed hash re-runs the action in all target clusters.
+func Test_action_hash_is_stable(t *testing.T) {
+	// ClusterAddonAction as it was before DeleteCmd and ValuesFrom were added and Values became structured.
+	// hashstructure includes the type name (but not the package) in the hash.
+	type ClusterAddonAction struct {
+		Type   v1alpha1.ClusterAddonActionType
+		Cmd    string
+		Values map[string]string
+	}
+	want, err := hashstructure.Hash(ClusterAddonAction{Cmd: "true", Values: map[string]string{"a": "b"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	action := &v1alpha1.ClusterAddonAction{
+		Cmd:        "true",
+		DeleteCmd:  "true",
+		Values:     raw(`{"a": "b"}`),
+		ValuesFrom: []v1alpha1.ValuesFromSource{{Prefix: "x"}},
+	}
+	values, err := decodeValues(action.Values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := actionHash(action, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got != want {
+		t.Errorf("got hash %d, want %d", got, want)
+	}
+
+	// Fields that have been added later do change the hash when set.
+	action.Path = "manifests"
+	got, err = actionHash(action, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == want {
+		t.Errorf("got hash %d, want a different hash when path is set", got)
+	}
+
+	// Switching render on changes the hash.
+	pathHash := got
+	action.Render = true
+	got, err = actionHash(action, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == pathHash {
+		t.Errorf("got hash %d, want a different hash when render is set", got)
+	}
+}
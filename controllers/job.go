@@ -0,0 +1,482 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	v1alpha1 "github.com/mmlt/operator-addons/api/v1alpha1"
+	"github.com/mmlt/operator-addons/internal/cluster"
+	"github.com/mmlt/operator-addons/internal/repogit"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// A shell action with an image runs as Job in the ClusterAddon namespace.
+//
+// File system of the Job container:
+//
+//	/repo/			source repository content (cloned by an init container or ConfigMap)
+//	/work/			working directory and $HOME (emptyDir)
+//		.kube/config	kubeconfig of the target cluster (Secret)
+//		values.yaml	values (Secret)
+//
+// The ConfigMap and Secret are created before the Job and owned by the Job once it exists so they are deleted with it.
+const (
+	jobRepoDir = "/repo"
+	jobWorkDir = "/work"
+
+	// MaxJobSourceSize is the max size of the repository content that fits in the ConfigMap of a Job.
+	maxJobSourceSize = 900 * 1024
+)
+
+// JobRun is the state of an action that runs as Job.
+type jobRun struct {
+	// Name of the Job.
+	name string
+	// Running is true until the Job has completed or failed.
+	running bool
+	// Start and end time of the Job.
+	start, end time.Time
+}
+
+// ErrJobRunning is returned when the action of a source that is torn down runs as Job and hasn't finished yet.
+var errJobRunning = errors.New("job is running")
+
+// JobName returns the name of the Job that runs the action of source n for the desired state.
+// The name changes when the action, values or repository commit change so each change runs once.
+// Attempt is the number of earlier failures and force the value of a force-sync request, they give retries and forced
+// runs their own Job.
+func jobName(clusterAddon *v1alpha1.ClusterAddon, n string, desired sourceState, attempt int, force string) string {
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%d/%s/%d", desired.ActionHash, desired.RepoSHA, attempt)
+	if force != "" {
+		fmt.Fprintf(h, "/%s", force)
+	}
+	suffix := fmt.Sprintf("-%08x", h.Sum32())
+
+	// Job names are limited to 63 characters because they're used as pod label value.
+	name := dnsLabel(clusterAddon.Name + "-" + n)
+	if max := 63 - len(suffix); len(name) > max {
+		name = strings.TrimRight(name[:max], "-")
+	}
+
+	return name + suffix
+}
+
+// DnsLabel returns s in lowercase with the characters that are not allowed in a DNS label replaced by '-'.
+func dnsLabel(s string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' {
+			return r
+		}
+		return '-'
+	}, strings.ToLower(s))
+}
+
+// RunJob runs the shell action of a source as Job with name.
+// The Job is created when it doesn't exist, the result is returned when the Job has finished.
+func (r *ClusterAddonReconciler) runJob(ctx context.Context, cl *cluster.Cluster, clusterAddon *v1alpha1.ClusterAddon, src *v1alpha1.ClusterAddonSource, env *actionEnv, name string) (*jobRun, *actionResult, error) {
+	run := &jobRun{name: name, running: true, start: time.Now()}
+
+	job := &batchv1.Job{}
+	err := r.Get(ctx, types.NamespacedName{Namespace: clusterAddon.Namespace, Name: name}, job)
+	if apierrors.IsNotFound(err) {
+		err = r.createJob(ctx, cl, clusterAddon, src, env, name)
+		if err != nil {
+			return run, &actionResult{}, fmt.Errorf("job %s: %w", name, err)
+		}
+		r.recorder.Event(clusterAddon, corev1.EventTypeNormal, "JobCreated", fmt.Sprintf("Job %s created for '%s'", name, env.source))
+		env.log.Info("Job created", "job", name)
+		return run, &actionResult{}, nil
+	}
+	if err != nil {
+		return run, &actionResult{}, err
+	}
+
+	run.start = job.CreationTimestamp.Time
+	if t := job.Status.StartTime; t != nil {
+		run.start = t.Time
+	}
+
+	finished := finishedCondition(job)
+	if finished == nil {
+		return run, &actionResult{}, nil
+	}
+	run.running = false
+	run.end = finished.LastTransitionTime.Time
+
+	out, err := r.jobLogs(ctx, job)
+	if err != nil {
+		// The Job result is still valid.
+		env.log.Error(err, "Job logs", "job", name)
+		out = fmt.Sprintf("logs of job %s not available: %v\n", name, err)
+	}
+	res := &actionResult{output: out}
+
+	if finished.Type == batchv1.JobFailed {
+		if finished.Reason == "DeadlineExceeded" {
+			return run, res, fmt.Errorf("job %s: %s: %w", name, finished.Message, context.DeadlineExceeded)
+		}
+		return run, res, fmt.Errorf("job %s failed: %s", name, finished.Message)
+	}
+
+	return run, res, nil
+}
+
+// TeardownJob runs the deleteCmd of the src action as Job in env.
+// It returns errJobRunning until the Job has finished, the finished Job is deleted so a next teardown runs again.
+func (r *ClusterAddonReconciler) teardownJob(ctx context.Context, cl *cluster.Cluster, clusterAddon *v1alpha1.ClusterAddon, src *v1alpha1.ClusterAddonSource, env *actionEnv) error {
+	name := jobName(clusterAddon, env.source, sourceState{RepoSHA: env.revision}, 0, "delete")
+	run, res, err := r.runJob(ctx, cl, clusterAddon, src, env, name)
+	if err == nil && run.running {
+		return errJobRunning
+	}
+	r.output(ctx, clusterAddon, env.source, src, run.start, res.output, env.log)
+
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Namespace: clusterAddon.Namespace, Name: name}}
+	e := r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground))
+	if e != nil && !apierrors.IsNotFound(e) {
+		env.log.Error(e, "Delete", "job", name)
+	}
+
+	return err
+}
+
+// FinishedCondition returns the Complete or Failed condition of a Job or nil when the Job hasn't finished.
+func finishedCondition(job *batchv1.Job) *batchv1.JobCondition {
+	for i, c := range job.Status.Conditions {
+		if (c.Type == batchv1.JobComplete || c.Type == batchv1.JobFailed) && c.Status == corev1.ConditionTrue {
+			return &job.Status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// CreateJob creates a Job with name that runs the cmd (or deleteCmd when env.delete is set) of the src action with the
+// ConfigMap and Secret it needs.
+// Jobs of earlier runs of the same source are deleted.
+func (r *ClusterAddonReconciler) createJob(ctx context.Context, cl *cluster.Cluster, clusterAddon *v1alpha1.ClusterAddon, src *v1alpha1.ClusterAddonSource, env *actionEnv, name string) error {
+	action := &src.Action
+	if action.Type != "" && action.Type != v1alpha1.RunTypeShell {
+		return fmt.Errorf("image is only supported for actions of type shell")
+	}
+	if action.Cmd == "" && !env.delete {
+		return fmt.Errorf("action of type shell requires cmd")
+	}
+
+	// A git source is cloned by the Job, other sources are copied via a ConfigMap.
+	var clone *jobClone
+	var files map[string][]byte
+	var err error
+	if isHTTPGit(src) && r.JobGitImage != "" {
+		token, err := r.tokenFor(ctx, clusterAddon.Namespace, src)
+		if err != nil {
+			return err
+		}
+		clone = &jobClone{image: r.JobGitImage, url: src.URL, revision: env.revision, token: token}
+	} else {
+		files, err = repoFiles(env.dir)
+		if err != nil {
+			return err
+		}
+	}
+	kc, err := cl.Kubeconfig()
+	if err != nil {
+		return err
+	}
+	values, err := yaml.Marshal(env.values)
+	if err != nil {
+		return err
+	}
+
+	err = r.DeleteAllOf(ctx, &batchv1.Job{}, client.InNamespace(clusterAddon.Namespace), client.MatchingLabels(env.labels),
+		client.PropagationPolicy(metav1.DeletePropagationBackground))
+	if err != nil {
+		return fmt.Errorf("delete previous jobs: %w", err)
+	}
+
+	job, cm, secret := jobObjects(clusterAddon.Namespace, name, action, env, clone, files, kc, values)
+	err = controllerutil.SetControllerReference(clusterAddon, job, r.Scheme)
+	if err != nil {
+		return err
+	}
+
+	// Create the ConfigMap and Secret before the Job so its pod finds them.
+	// Until the Job exists they're owned by the ClusterAddon.
+	deps := []metav1.Object{secret}
+	if cm != nil {
+		deps = append(deps, cm)
+	}
+	for _, obj := range deps {
+		err = controllerutil.SetControllerReference(clusterAddon, obj, r.Scheme)
+		if err != nil {
+			return err
+		}
+		err = r.Create(ctx, obj.(runtime.Object))
+		if err != nil && !apierrors.IsAlreadyExists(err) {
+			r.deleteObjects(ctx, deps, env)
+			return err
+		}
+	}
+	err = r.Create(ctx, job)
+	if err != nil {
+		r.deleteObjects(ctx, deps, env)
+		return err
+	}
+
+	// Hand the ConfigMap and Secret over to the Job so they're deleted with it.
+	owner := metav1.OwnerReference{APIVersion: "batch/v1", Kind: "Job", Name: job.Name, UID: job.UID}
+	for _, obj := range deps {
+		obj.SetOwnerReferences([]metav1.OwnerReference{owner})
+		err = r.Update(ctx, obj.(runtime.Object))
+		if err != nil {
+			// The Job runs, the object is deleted with the ClusterAddon.
+			env.log.Error(err, "Set owner", "name", obj.GetName())
+		}
+	}
+
+	return nil
+}
+
+// DeleteObjects deletes objs, errors are logged.
+func (r *ClusterAddonReconciler) deleteObjects(ctx context.Context, objs []metav1.Object, env *actionEnv) {
+	for _, obj := range objs {
+		err := r.Delete(ctx, obj.(runtime.Object))
+		if err != nil && !apierrors.IsNotFound(err) {
+			env.log.Error(err, "Delete", "name", obj.GetName())
+		}
+	}
+}
+
+// JobClone tells how the Job clones a git source.
+type jobClone struct {
+	// Image with git and sh.
+	image string
+	// Url of the repository and the revision (commit) to check out.
+	url, revision string
+	// Token to access the repository (optional).
+	token string
+}
+
+// IsHTTPGit returns true when src is a git repository that is accessed via http(s).
+func isHTTPGit(src *v1alpha1.ClusterAddonSource) bool {
+	return (src.Type == "" || src.Type == v1alpha1.SourceTypeGIT) &&
+		(strings.HasPrefix(src.URL, "https://") || strings.HasPrefix(src.URL, "http://"))
+}
+
+// JobObjects returns the Job with the Secret containing the kubeconfig and values.
+// When clone is nil the repository files are passed in a ConfigMap, otherwise an init container clones the repository
+// and the ConfigMap is nil.
+func jobObjects(namespace, name string, action *v1alpha1.ClusterAddonAction, env *actionEnv, clone *jobClone, files map[string][]byte, kubeconfig, values []byte) (*batchv1.Job, *corev1.ConfigMap, *corev1.Secret) {
+	meta := metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: env.labels}
+
+	mode := int32(0755)
+	var cm *corev1.ConfigMap
+	repo := corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}
+	if clone == nil {
+		cm = &corev1.ConfigMap{ObjectMeta: meta, BinaryData: map[string][]byte{}}
+		paths := make([]string, 0, len(files))
+		for p := range files {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+		items := make([]corev1.KeyToPath, 0, len(paths))
+		for i, p := range paths {
+			// ConfigMap keys can't contain '/' so the files are mapped to their path.
+			k := fmt.Sprintf("f%d", i)
+			cm.BinaryData[k] = files[p]
+			items = append(items, corev1.KeyToPath{Key: k, Path: p})
+		}
+		repo = corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{
+			LocalObjectReference: corev1.LocalObjectReference{Name: name},
+			Items:                items,
+			DefaultMode:          &mode,
+		}}
+	}
+
+	secret := &corev1.Secret{ObjectMeta: meta, Data: map[string][]byte{
+		"config":      kubeconfig,
+		"values.yaml": values,
+	}}
+	cmd, reconcile := action.Cmd, "CREATE_OR_UPDATE"
+	if env.delete {
+		cmd, reconcile = action.DeleteCmd, "DELETE"
+	}
+	vars := []corev1.EnvVar{
+		{Name: "REPODIR", Value: jobRepoDir},
+		{Name: "SOURCE_VERSION", Value: env.version},
+		{Name: "RECONCILE", Value: reconcile},
+		{Name: "HOME", Value: jobWorkDir},
+	}
+	for _, kv := range cluster.MapToEnv(env.values, "VALUE_") {
+		i := strings.Index(kv, "=")
+		k := kv[:i]
+		// Values might be secret so they're passed via the Secret.
+		secret.Data[k] = []byte(kv[i+1:])
+		vars = append(vars, corev1.EnvVar{Name: k, ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: name},
+			Key:                  k,
+		}}})
+	}
+
+	var initContainers []corev1.Container
+	volumes := []corev1.Volume{
+		{Name: "repo", VolumeSource: repo},
+		{Name: "work", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+		{Name: "secret", VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{
+			SecretName: name,
+		}}},
+	}
+	if clone != nil {
+		c, vs := cloneContainer(name, clone, secret)
+		initContainers = []corev1.Container{c}
+		volumes = append(volumes, vs...)
+	}
+
+	var backoffLimit int32
+	deadline := int64(actionTimeout(action).Seconds())
+	job := &batchv1.Job{
+		ObjectMeta: meta,
+		Spec: batchv1.JobSpec{
+			BackoffLimit:          &backoffLimit,
+			ActiveDeadlineSeconds: &deadline,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: env.labels},
+				Spec: corev1.PodSpec{
+					RestartPolicy:  corev1.RestartPolicyNever,
+					InitContainers: initContainers,
+					Containers: []corev1.Container{{
+						Name:       "action",
+						Image:      action.Image,
+						Command:    []string{"bash", "-c", cmd},
+						WorkingDir: jobWorkDir,
+						Env:        vars,
+						VolumeMounts: []corev1.VolumeMount{
+							{Name: "repo", MountPath: jobRepoDir, ReadOnly: true},
+							{Name: "work", MountPath: jobWorkDir},
+							{Name: "secret", MountPath: jobWorkDir + "/.kube/config", SubPath: "config", ReadOnly: true},
+							{Name: "secret", MountPath: jobWorkDir + "/values.yaml", SubPath: "values.yaml", ReadOnly: true},
+						},
+					}},
+					Volumes: volumes,
+				},
+			},
+		},
+	}
+
+	return job, cm, secret
+}
+
+// CloneContainer returns the init container that clones the repository into the repo volume and the volumes it needs.
+// The token is passed via secret (the Secret of the Job with name) to the git askpass program.
+func cloneContainer(name string, clone *jobClone, secret *corev1.Secret) (corev1.Container, []corev1.Volume) {
+	const askpassDir = "/askpass"
+	c := corev1.Container{
+		Name:    "clone",
+		Image:   clone.image,
+		Command: []string{"sh", "-c", `git clone --quiet --no-checkout "$GIT_URL" ` + jobRepoDir + ` && git -C ` + jobRepoDir + ` checkout --quiet --detach "$GIT_REVISION"`},
+		Env: []corev1.EnvVar{
+			{Name: "GIT_URL", Value: clone.url},
+			{Name: "GIT_REVISION", Value: clone.revision},
+			{Name: "GIT_TERMINAL_PROMPT", Value: "0"},
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			{Name: "repo", MountPath: jobRepoDir},
+		},
+	}
+	if clone.token == "" {
+		return c, nil
+	}
+
+	username, password := repogit.Credentials(clone.token)
+	secret.Data["git-askpass"] = []byte(repogit.AskpassScript)
+	secret.Data["git-username"] = []byte(username)
+	secret.Data["git-password"] = []byte(password)
+	for _, k := range []string{"username", "password"} {
+		c.Env = append(c.Env, corev1.EnvVar{Name: "GIT_AUTH_" + strings.ToUpper(k), ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: name},
+			Key:                  "git-" + k,
+		}}})
+	}
+	c.Env = append(c.Env, corev1.EnvVar{Name: "GIT_ASKPASS", Value: askpassDir + "/askpass"})
+	c.VolumeMounts = append(c.VolumeMounts, corev1.VolumeMount{Name: "askpass", MountPath: askpassDir, ReadOnly: true})
+
+	mode := int32(0555)
+	vs := []corev1.Volume{
+		{Name: "askpass", VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{
+			SecretName: name,
+			Items:      []corev1.KeyToPath{{Key: "git-askpass", Path: "askpass", Mode: &mode}},
+		}}},
+	}
+
+	return c, vs
+}
+
+// RepoFiles returns the content of the regular files in the directory tree at dir by path relative to dir.
+// The .git directory is skipped.
+func repoFiles(dir string) (map[string][]byte, error) {
+	files := map[string][]byte{}
+	var size int
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		size += int(info.Size())
+		if size > maxJobSourceSize {
+			return fmt.Errorf("source is larger than %d bytes", maxJobSourceSize)
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = b
+		return nil
+	})
+
+	return files, err
+}
+
+// JobLogs returns the logs of the pods of a Job.
+func (r *ClusterAddonReconciler) jobLogs(ctx context.Context, job *batchv1.Job) (string, error) {
+	pods := &corev1.PodList{}
+	err := r.List(ctx, pods, client.InNamespace(job.Namespace), client.MatchingLabels{"job-name": job.Name})
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	for _, pod := range pods.Items {
+		b, err := r.podLogs(pod.Namespace, pod.Name)
+		if err != nil {
+			return sb.String(), err
+		}
+		sb.Write(b)
+	}
+
+	return sb.String(), nil
+}
@@ -0,0 +1,293 @@
+package controllers
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mmlt/operator-addons/api/v1alpha1"
+	"github.com/mmlt/operator-addons/internal/cluster"
+	"github.com/mmlt/operator-addons/internal/repogit"
+	"github.com/mmlt/operator-addons/internal/runlog"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+)
+
+func Test_jobName(t *testing.T) {
+	ca := &v1alpha1.ClusterAddon{ObjectMeta: metav1.ObjectMeta{Name: "Test_Cluster"}}
+
+	a := jobName(ca, "base", sourceState{ActionHash: 1, RepoSHA: "abc"}, 0, "")
+	if !strings.HasPrefix(a, "test-cluster-base-") {
+		t.Errorf("got %s, want prefix test-cluster-base-", a)
+	}
+	if b := jobName(ca, "base", sourceState{ActionHash: 1, RepoSHA: "def"}, 0, ""); a == b {
+		t.Errorf("name %s doesn't change with the commit", a)
+	}
+	if b := jobName(ca, "base", sourceState{ActionHash: 1, RepoSHA: "abc"}, 1, ""); a == b {
+		t.Errorf("name %s doesn't change with the attempt", a)
+	}
+	if b := jobName(ca, "base", sourceState{ActionHash: 1, RepoSHA: "abc"}, 0, "now"); a == b {
+		t.Errorf("name %s doesn't change with force-sync", a)
+	}
+
+	long := jobName(ca, strings.Repeat("x", 100), sourceState{}, 0, "")
+	if len(long) > 63 {
+		t.Errorf("got %d characters, want max 63", len(long))
+	}
+}
+
+func Test_repoFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "repofiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for p, s := range map[string]string{
+		"run.sh":      "echo hi",
+		"deploy/a.sh": "echo a",
+		".git/HEAD":   "ref",
+	} {
+		p = filepath.Join(dir, p)
+		_ = os.MkdirAll(filepath.Dir(p), 0755)
+		if err := ioutil.WriteFile(p, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := repoFiles(dir)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]byte{
+		"run.sh":      []byte("echo hi"),
+		"deploy/a.sh": []byte("echo a"),
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("diff (- = got, + = want) %s", diff)
+	}
+}
+
+func Test_runJob(t *testing.T) {
+	ca := &v1alpha1.ClusterAddon{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "ca", UID: "1"}}
+	r := testReconciler(ca)
+	r.recorder = record.NewFakeRecorder(10)
+	r.podLogs = func(namespace, name string) ([]byte, error) {
+		return []byte("hello from " + name + "\n"), nil
+	}
+	cl, err := cluster.New("ns-ca", testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cl.Path)
+	err = cl.SetServerCoordinates("https://127.0.0.1:6443", nil, "user", "password", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "runjob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := &v1alpha1.ClusterAddonSource{Action: v1alpha1.ClusterAddonAction{Cmd: "./run.sh", Image: "tools:1"}}
+	env := &actionEnv{source: "base", dir: dir, values: map[string]interface{}{"a": "x"}, labels: ownerLabels(ca, "base"), log: testLogger()}
+	ctx := context.Background()
+
+	// First run creates the Job.
+	run, _, err := r.runJob(ctx, cl, ca, src, env, "ca-base-1")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !run.running {
+		t.Error("expected running")
+	}
+	job := &batchv1.Job{}
+	err = r.Get(ctx, types.NamespacedName{Namespace: "ns", Name: "ca-base-1"}, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := job.Spec.Template.Spec.Containers[0].Image; got != "tools:1" {
+		t.Errorf("got image %s, want tools:1", got)
+	}
+	secret := &corev1.Secret{}
+	err = r.Get(ctx, types.NamespacedName{Namespace: "ns", Name: "ca-base-1"}, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(secret.Data["VALUE_A"]); got != "x" {
+		t.Errorf("got VALUE_A %q, want x", got)
+	}
+	if got := secret.OwnerReferences; len(got) != 1 || got[0].Kind != "Job" || got[0].Name != "ca-base-1" {
+		t.Errorf("got owners %v, want Job ca-base-1", got)
+	}
+
+	// Job fails.
+	job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Message: "BackoffLimitExceeded"}}
+	if err := r.Status().Update(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "ca-base-1-abcde", Labels: map[string]string{"job-name": "ca-base-1"}}}
+	if err := r.Create(ctx, pod); err != nil {
+		t.Fatal(err)
+	}
+
+	run, res, err := r.runJob(ctx, cl, ca, src, env, "ca-base-1")
+
+	if err == nil {
+		t.Error("expected error")
+	}
+	if run.running {
+		t.Error("expected not running")
+	}
+	if want := "hello from ca-base-1-abcde\n"; res.output != want {
+		t.Errorf("got output %q, want %q", res.output, want)
+	}
+}
+
+func Test_teardownJob(t *testing.T) {
+	ca := &v1alpha1.ClusterAddon{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "ca", UID: "1"}}
+	r := testReconciler(ca)
+	r.recorder = record.NewFakeRecorder(10)
+	r.podLogs = func(namespace, name string) ([]byte, error) {
+		return []byte("deleted\n"), nil
+	}
+	cl, err := cluster.New("ns-ca", testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cl.Path)
+	err = cl.SetServerCoordinates("https://127.0.0.1:6443", nil, "user", "password", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "teardownjob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	r.Runlog = &runlog.Runlog{Dir: dir, Keep: 1}
+	src := &v1alpha1.ClusterAddonSource{Action: v1alpha1.ClusterAddonAction{Cmd: "./run.sh", DeleteCmd: "./delete.sh", Image: "tools:1"}}
+	env := &actionEnv{source: "base", dir: dir, labels: ownerLabels(ca, "base"), delete: true, log: testLogger()}
+	ctx := context.Background()
+	name := jobName(ca, "base", sourceState{}, 0, "delete")
+
+	// First teardown creates the Job.
+	err = r.teardownJob(ctx, cl, ca, src, env)
+
+	if err != errJobRunning {
+		t.Fatalf("got error %v, want errJobRunning", err)
+	}
+	job := &batchv1.Job{}
+	err = r.Get(ctx, types.NamespacedName{Namespace: "ns", Name: name}, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := job.Spec.Template.Spec.Containers[0]
+	if got, want := c.Command, []string{"bash", "-c", "./delete.sh"}; !cmp.Equal(got, want) {
+		t.Errorf("got command %v, want %v", got, want)
+	}
+	vars := map[string]string{}
+	for _, v := range c.Env {
+		vars[v.Name] = v.Value
+	}
+	if got := vars["RECONCILE"]; got != "DELETE" {
+		t.Errorf("got RECONCILE %q, want DELETE", got)
+	}
+
+	// Job completes.
+	job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+	if err := r.Status().Update(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+
+	err = r.teardownJob(ctx, cl, ca, src, env)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.Get(ctx, types.NamespacedName{Namespace: "ns", Name: name}, &batchv1.Job{})
+	if err == nil {
+		t.Error("expected finished Job to be deleted")
+	}
+}
+
+func Test_runJob_clone(t *testing.T) {
+	ca := &v1alpha1.ClusterAddon{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "ca", UID: "1"}}
+	r := testReconciler(ca)
+	r.recorder = record.NewFakeRecorder(10)
+	r.JobGitImage = "git:1"
+	cl, err := cluster.New("ns-ca", testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cl.Path)
+	err = cl.SetServerCoordinates("https://127.0.0.1:6443", nil, "user", "password", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := &v1alpha1.ClusterAddonSource{URL: "https://example.com/addons.git", Token: "secret-token",
+		Action: v1alpha1.ClusterAddonAction{Cmd: "./run.sh", Image: "tools:1"}}
+	// The dir isn't read when the Job clones the repository.
+	env := &actionEnv{source: "base", dir: "/nonexistent", revision: "abc123", labels: ownerLabels(ca, "base"), log: testLogger()}
+	ctx := context.Background()
+
+	_, _, err = r.runJob(ctx, cl, ca, src, env, "ca-base-1")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := &batchv1.Job{}
+	err = r.Get(ctx, types.NamespacedName{Namespace: "ns", Name: "ca-base-1"}, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := job.Spec.Template.Spec
+	if len(spec.InitContainers) != 1 {
+		t.Fatalf("got %d init containers, want 1", len(spec.InitContainers))
+	}
+	vars := map[string]string{}
+	for _, v := range spec.InitContainers[0].Env {
+		vars[v.Name] = v.Value
+		if v.ValueFrom != nil {
+			vars[v.Name] = "secret:" + v.ValueFrom.SecretKeyRef.Key
+		}
+	}
+	want := map[string]string{
+		"GIT_URL":             "https://example.com/addons.git",
+		"GIT_REVISION":        "abc123",
+		"GIT_TERMINAL_PROMPT": "0",
+		"GIT_ASKPASS":         "/askpass/askpass",
+		"GIT_AUTH_USERNAME":   "secret:git-username",
+		"GIT_AUTH_PASSWORD":   "secret:git-password",
+	}
+	if diff := cmp.Diff(vars, want); diff != "" {
+		t.Errorf("diff (- = got, + = want) %s", diff)
+	}
+	if spec.Volumes[0].EmptyDir == nil {
+		t.Errorf("got repo volume %v, want emptyDir", spec.Volumes[0].VolumeSource)
+	}
+
+	secret := &corev1.Secret{}
+	err = r.Get(ctx, types.NamespacedName{Namespace: "ns", Name: "ca-base-1"}, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(secret.Data["git-username"]); got != "secret-token" {
+		t.Errorf("got git-username %q, want the token", got)
+	}
+	if got := string(secret.Data["git-askpass"]); got != repogit.AskpassScript {
+		t.Errorf("got git-askpass %q, want the askpass script", got)
+	}
+	err = r.Get(ctx, types.NamespacedName{Namespace: "ns", Name: "ca-base-1"}, &corev1.ConfigMap{})
+	if err == nil {
+		t.Error("expected no ConfigMap")
+	}
+}
@@ -0,0 +1,116 @@
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"path/filepath"
+	"time"
+
+	"github.com/go-logr/logr"
+	v1alpha1 "github.com/mmlt/operator-addons/api/v1alpha1"
+	"github.com/mmlt/operator-addons/internal/runlog"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// OutputTailSize is the maximum number of bytes of action output that is shown in the ClusterAddon status.
+const outputTailSize = 2048
+
+// Output masks the secrets in the output of an action that started at time t, writes it to the run log and
+// returns the tail of it.
+// Failing to write the run log is logged but doesn't fail the action.
+func (r *ClusterAddonReconciler) output(ctx context.Context, clusterAddon *v1alpha1.ClusterAddon, n string, src *v1alpha1.ClusterAddonSource, t time.Time, out string, log logr.Logger) string {
+	return runlog.Tail(r.writeRunlog(ctx, clusterAddon, n, src, t, out, log), outputTailSize)
+}
+
+// WriteRunlog masks the secrets in the output of an action that started at time t, writes it to the run log and
+// returns the masked output.
+func (r *ClusterAddonReconciler) writeRunlog(ctx context.Context, clusterAddon *v1alpha1.ClusterAddon, n string, src *v1alpha1.ClusterAddonSource, t time.Time, out string, log logr.Logger) string {
+	out = runlog.Mask(out, r.secretsFor(ctx, clusterAddon, src))
+
+	p, err := r.Runlog.Write(filepath.Join(clusterAddon.Namespace, clusterAddon.Name, n), t, out)
+	if err != nil {
+		log.Error(err, "Write run log")
+	} else {
+		log.V(1).Info("Write run log", "path", p)
+	}
+
+	return out
+}
+
+// RemoveRunlog removes the run logs of owner (namespace/clusteraddon or namespace/clusteraddon/source).
+func (r *ClusterAddonReconciler) removeRunlog(owner string, log logr.Logger) {
+	err := r.Runlog.Remove(owner)
+	if err != nil {
+		log.Error(err, "Remove run log")
+	}
+}
+
+// SecretsFor returns the secret values that should not be shown in the output of the src action.
+// It includes the inline credentials, the values of all Secrets clusterAddon and src refer to and the credentials
+// in the target kubeconfig.
+func (r *ClusterAddonReconciler) secretsFor(ctx context.Context, clusterAddon *v1alpha1.ClusterAddon, src *v1alpha1.ClusterAddonSource) []string {
+	target := &clusterAddon.Spec.Target
+	secrets := []string{target.Password, src.Token}
+	if b, err := base64.StdEncoding.DecodeString(target.Password); err == nil {
+		secrets = append(secrets, string(b))
+	}
+	secrets = append(secrets, clientKeySecrets(target.ClientKey)...)
+	for _, s := range clusterAddon.Spec.Sources {
+		secrets = append(secrets, s.Token)
+	}
+
+	// Src might have been removed from the spec, include its Secrets explicitly.
+	ca := &v1alpha1.ClusterAddon{Spec: v1alpha1.ClusterAddonSpec{Sources: map[string]v1alpha1.ClusterAddonSource{"": *src}}}
+	names := append(secretNames(clusterAddon), secretNames(ca)...)
+	for _, name := range names {
+		secret := &corev1.Secret{}
+		err := r.Get(ctx, types.NamespacedName{Namespace: clusterAddon.Namespace, Name: name}, secret)
+		if err != nil {
+			// A missing Secret has been reported when the action values or credentials were read.
+			continue
+		}
+		for _, v := range secret.Data {
+			secrets = append(secrets, string(v))
+		}
+		if ref := target.SecretRef; ref != nil && ref.Name == name {
+			secrets = append(secrets, clientKeySecrets(secret.Data["clientKey"])...)
+		}
+		if ref := target.KubeconfigSecretRef; ref != nil && ref.Name == name {
+			secrets = append(secrets, kubeconfigSecrets(secret.Data[ref.Key])...)
+		}
+	}
+
+	return secrets
+}
+
+// KubeconfigSecrets returns the credentials in a kubeconfig.
+// A kubeconfig that can't be parsed has been reported when the target cluster was accessed.
+func kubeconfigSecrets(kubeconfig []byte) []string {
+	kc, err := clientcmd.Load(kubeconfig)
+	if err != nil {
+		return nil
+	}
+
+	var secrets []string
+	for _, u := range kc.AuthInfos {
+		secrets = append(secrets, u.Token, u.Password)
+		secrets = append(secrets, clientKeySecrets(u.ClientKeyData)...)
+		if u.AuthProvider != nil {
+			for _, v := range u.AuthProvider.Config {
+				secrets = append(secrets, v)
+			}
+		}
+	}
+
+	return secrets
+}
+
+// ClientKeySecrets returns a client key as-is and base64 encoded like it's written in a kubeconfig.
+func clientKeySecrets(key []byte) []string {
+	if len(key) == 0 {
+		return nil
+	}
+	return []string{string(key), base64.StdEncoding.EncodeToString(key)}
+}
@@ -0,0 +1,133 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	v1alpha1 "github.com/mmlt/operator-addons/api/v1alpha1"
+	"github.com/mmlt/operator-addons/internal/cluster"
+	"github.com/mmlt/operator-addons/internal/runlog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// PlanDiffSize is the maximum number of bytes of a plan that is shown in the ClusterAddon status.
+const planDiffSize = 8192
+
+// Plan reports the changes that applying src would make to the target cluster in ss.
+// A plan is made once for each source commit and action.
+// It returns the condition that tells the plan is ready or why it failed.
+func (r *ClusterAddonReconciler) plan(ctx context.Context, cl *cluster.Cluster, clusterAddon *v1alpha1.ClusterAddon, n string, src *v1alpha1.ClusterAddonSource, env *actionEnv, desired, current sourceState, ss *v1alpha1.SourceStatus, log logr.Logger) v1alpha1.ClusterAddonCondition {
+	sha, hash := desired.RepoSHA, strconv.FormatUint(desired.ActionHash, 10)
+	ready := condition(v1alpha1.ClusterAddonSynced, false, "PlanReady", fmt.Sprintf("Plan of '%s' is ready", n))
+	if p := ss.Plan; p != nil && p.SHA == sha && p.ActionHash == hash {
+		return ready
+	}
+
+	start := metav1.Now().Rfc3339Copy()
+	timeout := actionTimeout(&src.Action)
+	actx, cancel := context.WithTimeout(ctx, timeout)
+	out, changes, err := planAction(actx, cl, &src.Action, env, current.Inventory, clusterAddon.Spec.Policy)
+	cancel()
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = fmt.Errorf("timeout after %s: %w", timeout, err)
+	}
+
+	out = r.writeRunlog(ctx, clusterAddon, n, src, start.Time, out, log)
+	if err != nil {
+		ss.LastError = err.Error()
+		ss.Output = runlog.Tail(out, outputTailSize)
+		log.Error(err, "Plan")
+		r.recorder.Event(clusterAddon, corev1.EventTypeWarning, "PlanFailed", fmt.Sprintf("Plan '%s' failed", n))
+		return condition(v1alpha1.ClusterAddonActionOk, false, reasonFor(err), err.Error())
+	}
+
+	diff := runlog.Head(out, planDiffSize)
+	if len(diff) < len(out) {
+		diff += "...\n"
+	}
+	ss.Plan = &v1alpha1.SourcePlan{SHA: sha, ActionHash: hash, Time: &start, Changes: changes, Diff: diff}
+	r.recorder.Event(clusterAddon, corev1.EventTypeNormal, "Planned", fmt.Sprintf("Plan of '%s' is ready", n))
+	log.Info(fmt.Sprintf("Plan of '%s' is ready", n), "changes", changes, "duration", time.Since(start.Time))
+
+	return ready
+}
+
+// PlanAction returns the plan of action and whether action would change the target cluster.
+// Previous are the objects that have been applied before, they're used to plan pruning.
+func planAction(ctx context.Context, cl *cluster.Cluster, action *v1alpha1.ClusterAddonAction, env *actionEnv, previous []cluster.ObjectRef, policy v1alpha1.ClusterAddonPolicy) (string, bool, error) {
+	if action.PlanCmd != "" {
+		if action.Image != "" {
+			return "", false, fmt.Errorf("planCmd can't be combined with image")
+		}
+		out, err := cl.RunShell(ctx, action.PlanCmd, env.values, []string{"REPODIR=" + env.dir, "SOURCE_VERSION=" + env.version, "RECONCILE=PLAN"})
+		var ee *exec.ExitError
+		if errors.As(err, &ee) && ee.ExitCode() == 1 {
+			// Like kubectl diff; 1 means there are changes.
+			return out, true, nil
+		}
+		return out, false, err
+	}
+
+	switch action.Type {
+	case v1alpha1.RunTypeManifests, v1alpha1.RunTypeKustomize, v1alpha1.RunTypeTemplate:
+		objs, err := objectsFor(action, env)
+		if err != nil {
+			return "", false, err
+		}
+		return planObjects(cl, objs, previous, policy)
+	}
+
+	t := action.Type
+	if t == "" {
+		t = v1alpha1.RunTypeShell
+	}
+	return "", false, fmt.Errorf("plan of action type %s requires planCmd", t)
+}
+
+// PlanObjects returns the changes applying objs would make to the target cluster.
+// The output lists the change of each object like kubectl apply does followed by the diff.
+func planObjects(cl *cluster.Cluster, objs []*unstructured.Unstructured, previous []cluster.ObjectRef, policy v1alpha1.ClusterAddonPolicy) (string, bool, error) {
+	results, err := cl.Plan(objs)
+	if err != nil {
+		return "", false, fmt.Errorf("plan: %w", err)
+	}
+
+	var out strings.Builder
+	var changes bool
+	var failed int
+	inventory := make([]cluster.ObjectRef, 0, len(results))
+	for _, r := range results {
+		inventory = append(inventory, cluster.RefOf(r.Object))
+		name := strings.ToLower(r.Object.GetKind()) + "/" + r.Object.GetName()
+		if r.Err != nil {
+			fmt.Fprintf(&out, "%s failed %v\n", name, r.Err)
+			failed++
+			continue
+		}
+		fmt.Fprintf(&out, "%s %s\n", name, r.Change)
+		if r.Diff != "" {
+			fmt.Fprintln(&out, strings.TrimRight(r.Diff, "\n"))
+		}
+		changes = changes || r.Change != cluster.ChangeUnchanged
+	}
+
+	if previous != nil && allowed(policy, opDelete) {
+		for _, ref := range staleObjects(previous, inventory) {
+			fmt.Fprintf(&out, "%s pruned\n", ref)
+			changes = true
+		}
+	}
+
+	if failed > 0 {
+		return out.String(), changes, fmt.Errorf("plan: %d of %d objects failed", failed, len(results))
+	}
+	return out.String(), changes, nil
+}
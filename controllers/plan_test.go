@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/mmlt/operator-addons/api/v1alpha1"
+	"github.com/mmlt/operator-addons/internal/cluster"
+)
+
+func Test_planAction_with_planCmd(t *testing.T) {
+	cl, err := cluster.New("plan-test", testLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cl.Path)
+	env := &actionEnv{source: "base", dir: "/repo", log: testLogger()}
+
+	tests := []struct {
+		it          string
+		planCmd     string
+		wantOut     string
+		wantChanges bool
+		wantErr     bool
+	}{
+		{it: "has_no_changes", planCmd: "echo $RECONCILE", wantOut: "PLAN\n"},
+		{it: "has_changes", planCmd: "echo diff; exit 1", wantOut: "diff\n", wantChanges: true},
+		{it: "fails", planCmd: "echo oops; exit 2", wantOut: "oops\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.it, func(t *testing.T) {
+			action := &v1alpha1.ClusterAddonAction{Type: v1alpha1.RunTypeHelm, PlanCmd: tt.planCmd}
+
+			out, changes, err := planAction(context.Background(), cl, action, env, nil, v1alpha1.AllowAll)
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %t", err, tt.wantErr)
+			}
+			if out != tt.wantOut {
+				t.Errorf("got output %q, want %q", out, tt.wantOut)
+			}
+			if changes != tt.wantChanges {
+				t.Errorf("got changes %t, want %t", changes, tt.wantChanges)
+			}
+		})
+	}
+}
+
+func Test_planAction_requires_planCmd(t *testing.T) {
+	_, _, err := planAction(context.Background(), nil, &v1alpha1.ClusterAddonAction{Cmd: "true"}, &actionEnv{}, nil, v1alpha1.AllowAll)
+	if err == nil {
+		t.Error("expected error")
+	}
+}
+
+func Test_planAction_rejects_planCmd_with_image(t *testing.T) {
+	action := &v1alpha1.ClusterAddonAction{Cmd: "true", PlanCmd: "true", Image: "tools:1"}
+	_, _, err := planAction(context.Background(), nil, action, &actionEnv{}, nil, v1alpha1.AllowAll)
+	if err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+
+	v1alpha1 "github.com/mmlt/operator-addons/api/v1alpha1"
+)
+
+// Operation is a change of a source in the target cluster.
+type operation string
+
+const (
+	// OpNone means the source in the target cluster is up-to-date.
+	opNone operation = ""
+	// OpCreate means the source is applied to the target cluster for the first time.
+	opCreate operation = "Create"
+	// OpUpdate means a changed repo or action is applied to the target cluster.
+	opUpdate operation = "Update"
+	// OpDelete means the source is removed from the target cluster.
+	opDelete operation = "Delete"
+)
+
+// OperationFor returns the operation needed to bring source 'name' from the current to the desired state.
+func operationFor(current *state, name string, desired sourceState) operation {
+	cur, ok := current.Sources[name]
+	if !ok {
+		return opCreate
+	}
+	if cur.RepoSHA == desired.RepoSHA && cur.ActionHash == desired.ActionHash {
+		return opNone
+	}
+	return opUpdate
+}
+
+// Allowed returns true when policy allows op to be performed on the target cluster.
+func allowed(policy v1alpha1.ClusterAddonPolicy, op operation) bool {
+	switch policy {
+	case v1alpha1.DenyDelete:
+		return op != opDelete
+	case v1alpha1.DenyUpdate:
+		return op == opNone || op == opCreate
+	default:
+		return true
+	}
+}
+
+// PendingChange returns a human readable description of the change from current to desired source state.
+func pendingChange(current, desired sourceState) string {
+	var m string
+	if current.RepoSHA != desired.RepoSHA {
+		m = fmt.Sprintf("repo %s -> %s", shortSHA(current.RepoSHA), shortSHA(desired.RepoSHA))
+	}
+	if current.ActionHash != desired.ActionHash {
+		if m != "" {
+			m += ", "
+		}
+		m += "action changed"
+	}
+	return m
+}
+
+// ShortSHA returns the abbreviated form of a commit SHA or digest (the algorithm prefix is removed).
+func shortSHA(sha string) string {
+	if i := strings.Index(sha, ":"); i >= 0 {
+		sha = sha[i+1:]
+	}
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
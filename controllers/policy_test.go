@@ -0,0 +1,302 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mmlt/operator-addons/api/v1alpha1"
+	"github.com/mmlt/operator-addons/internal/cluster"
+	"github.com/mmlt/operator-addons/internal/runlog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func Test_operationFor(t *testing.T) {
+	current := &state{Sources: map[string]sourceState{
+		"base": {RepoSHA: "a3a053fb28df45e33db1b634c1a45cb76e3d8bdf", ActionHash: 1},
+	}}
+
+	tests := []struct {
+		name    string
+		source  string
+		desired sourceState
+		want    operation
+	}{
+		{
+			name:    "new_source",
+			source:  "other",
+			desired: sourceState{RepoSHA: "a3a053fb28df45e33db1b634c1a45cb76e3d8bdf", ActionHash: 1},
+			want:    opCreate,
+		},
+		{
+			name:    "unchanged_source",
+			source:  "base",
+			desired: sourceState{RepoSHA: "a3a053fb28df45e33db1b634c1a45cb76e3d8bdf", ActionHash: 1},
+			want:    opNone,
+		},
+		{
+			name:    "repo_changed",
+			source:  "base",
+			desired: sourceState{RepoSHA: "1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e", ActionHash: 1},
+			want:    opUpdate,
+		},
+		{
+			name:    "action_changed",
+			source:  "base",
+			desired: sourceState{RepoSHA: "a3a053fb28df45e33db1b634c1a45cb76e3d8bdf", ActionHash: 2},
+			want:    opUpdate,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := operationFor(current, tt.source, tt.desired); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_allowed(t *testing.T) {
+	tests := []struct {
+		policy v1alpha1.ClusterAddonPolicy
+		want   map[operation]bool
+	}{
+		{
+			policy: "",
+			want:   map[operation]bool{opNone: true, opCreate: true, opUpdate: true, opDelete: true},
+		},
+		{
+			policy: v1alpha1.AllowAll,
+			want:   map[operation]bool{opNone: true, opCreate: true, opUpdate: true, opDelete: true},
+		},
+		{
+			policy: v1alpha1.DenyDelete,
+			want:   map[operation]bool{opNone: true, opCreate: true, opUpdate: true, opDelete: false},
+		},
+		{
+			policy: v1alpha1.DenyUpdate,
+			want:   map[operation]bool{opNone: true, opCreate: true, opUpdate: false, opDelete: false},
+		},
+	}
+	for _, tt := range tests {
+		for op, want := range tt.want {
+			t.Run(string(tt.policy)+"_"+string(op), func(t *testing.T) {
+				if got := allowed(tt.policy, op); got != want {
+					t.Errorf("got %v, want %v", got, want)
+				}
+			})
+		}
+	}
+}
+
+func Test_pendingChange(t *testing.T) {
+	current := sourceState{RepoSHA: "a3a053fb28df45e33db1b634c1a45cb76e3d8bdf", ActionHash: 1}
+	desired := sourceState{RepoSHA: "1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e", ActionHash: 2}
+
+	want := "repo a3a053f -> 1b2c3d4, action changed"
+	if got := pendingChange(current, desired); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func Test_shortSHA(t *testing.T) {
+	for sha, want := range map[string]string{
+		"a3a053fb28df45e33db1b634c1a45cb76e3d8bdf":  "a3a053f",
+		"sha256:1b2c3d4e5f60718293a4b5c6d7e8f90a1b": "1b2c3d4",
+		"abc": "abc",
+	} {
+		if got := shortSHA(sha); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func Test_policy_reconcile(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy v1alpha1.ClusterAddonPolicy
+		// Op is the change to reconcile: create, update or remove a source or delete the ClusterAddon.
+		op operation
+		// CR is true when the ClusterAddon is deleted.
+		cr bool
+		// Want is true when the target cluster is changed.
+		want bool
+	}{
+		{name: "AllowAll_create", policy: v1alpha1.AllowAll, op: opCreate, want: true},
+		{name: "AllowAll_update", policy: v1alpha1.AllowAll, op: opUpdate, want: true},
+		{name: "AllowAll_remove", policy: v1alpha1.AllowAll, op: opDelete, want: true},
+		{name: "AllowAll_delete", policy: v1alpha1.AllowAll, op: opDelete, cr: true, want: true},
+		{name: "DenyDelete_update", policy: v1alpha1.DenyDelete, op: opUpdate, want: true},
+		{name: "DenyDelete_remove", policy: v1alpha1.DenyDelete, op: opDelete, want: false},
+		{name: "DenyDelete_delete", policy: v1alpha1.DenyDelete, op: opDelete, cr: true, want: false},
+		{name: "DenyUpdate_create", policy: v1alpha1.DenyUpdate, op: opCreate, want: true},
+		{name: "DenyUpdate_update", policy: v1alpha1.DenyUpdate, op: opUpdate, want: false},
+		{name: "DenyUpdate_remove", policy: v1alpha1.DenyUpdate, op: opDelete, want: false},
+		{name: "DenyUpdate_delete", policy: v1alpha1.DenyUpdate, op: opDelete, cr: true, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "policy")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			target := newTestTarget()
+			defer target.Close()
+
+			// The cmd and deleteCmd leave a marker file when they run.
+			marker := filepath.Join(dir, "marker")
+			src := v1alpha1.ClusterAddonSource{
+				Type:  v1alpha1.SourceTypeInline,
+				Files: map[string]string{"a.yaml": "kind: A"},
+				Action: v1alpha1.ClusterAddonAction{
+					Cmd:       "touch " + marker,
+					DeleteCmd: "touch " + marker,
+				},
+			}
+			ca := &v1alpha1.ClusterAddon{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "ca"},
+				Spec: v1alpha1.ClusterAddonSpec{
+					Policy:  tt.policy,
+					Sources: map[string]v1alpha1.ClusterAddonSource{"base": src},
+				},
+			}
+
+			// The target cluster state before the reconcile.
+			st := &state{Sources: map[string]sourceState{}}
+			switch {
+			case tt.cr, tt.op == opUpdate:
+				st.Sources["base"] = sourceState{RepoSHA: "old", ActionHash: 1, Source: withoutCredentials(&src)}
+			case tt.op == opDelete:
+				// A removed source without deleteCmd deletes its inventory.
+				ca.Spec.Sources = nil
+				target.put(t, "configmaps", &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "a",
+					Labels: ownerLabels(ca, "old")}})
+				st.Sources["old"] = sourceState{RepoSHA: "old", ActionHash: 1,
+					Source:    &v1alpha1.ClusterAddonSource{Type: v1alpha1.SourceTypeInline},
+					Inventory: []cluster.ObjectRef{{APIVersion: "v1", Kind: "ConfigMap", Namespace: "default", Name: "a"}}}
+			}
+			b, err := json.Marshal(st)
+			if err != nil {
+				t.Fatal(err)
+			}
+			target.put(t, "configmaps", &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: cluster.CMNamespace, Name: cluster.CMName},
+				Data: map[string]string{fieldName: string(b)}})
+			target.writes = nil
+
+			r := testReconciler(ca)
+			recorder := record.NewFakeRecorder(10)
+			r.recorder = recorder
+			r.Repos = map[string]Repo{}
+			defer r.removeRepos("ns", "", testLogger())
+			r.Runlog = &runlog.Runlog{Dir: filepath.Join(dir, "runlog"), Keep: 1}
+			cl := target.cluster(t)
+			defer os.RemoveAll(cl.Path)
+
+			if tt.cr {
+				_, err = r.delete(context.Background(), cl, ca, testLogger())
+			} else {
+				_, err = r.createOrUpdate(context.Background(), cl, ca, testLogger())
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Writes of the state are bookkeeping, other writes change the add-ons.
+			stateKey := path.Join(cluster.CMNamespace, "configmaps", cluster.CMName)
+			var writes []string
+			for _, w := range target.writes {
+				if w != "POST "+stateKey && w != "PUT "+stateKey {
+					writes = append(writes, w)
+				}
+			}
+			_, err = os.Stat(marker)
+			ran := err == nil
+			if got := ran || len(writes) > 0; got != tt.want {
+				t.Errorf("got target changed %v (cmd ran %v, writes %v), want %v", got, ran, writes, tt.want)
+			}
+			if !tt.want && !tt.cr {
+				// The change is reported instead.
+				select {
+				case e := <-recorder.Events:
+					if !strings.Contains(e, "by policy "+string(tt.policy)) {
+						t.Errorf("got event %q, want a change denied by policy", e)
+					}
+				default:
+					t.Error("expected an event")
+				}
+			}
+		})
+	}
+}
+
+func Test_policy_plan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := newTestTarget()
+	defer target.Close()
+
+	marker := filepath.Join(dir, "marker")
+	src := v1alpha1.ClusterAddonSource{
+		Type:  v1alpha1.SourceTypeInline,
+		Files: map[string]string{"a.yaml": "kind: A"},
+		Action: v1alpha1.ClusterAddonAction{
+			Cmd:     "touch " + marker,
+			PlanCmd: "echo changed; exit 1",
+		},
+	}
+	ca := &v1alpha1.ClusterAddon{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "ca"},
+		Spec: v1alpha1.ClusterAddonSpec{
+			Mode:    v1alpha1.ModePlan,
+			Policy:  v1alpha1.DenyUpdate,
+			Sources: map[string]v1alpha1.ClusterAddonSource{"base": src},
+		},
+	}
+	st := &state{Sources: map[string]sourceState{
+		"base": {RepoSHA: "old", ActionHash: 1, Source: withoutCredentials(&src)},
+	}}
+	b, err := json.Marshal(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target.put(t, "configmaps", &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: cluster.CMNamespace, Name: cluster.CMName},
+		Data: map[string]string{fieldName: string(b)}})
+
+	r := testReconciler(ca)
+	recorder := record.NewFakeRecorder(10)
+	r.recorder = recorder
+	r.Repos = map[string]Repo{}
+	defer r.removeRepos("ns", "", testLogger())
+	r.Runlog = &runlog.Runlog{Dir: filepath.Join(dir, "runlog"), Keep: 1}
+	cl := target.cluster(t)
+	defer os.RemoveAll(cl.Path)
+
+	status, err := r.createOrUpdate(context.Background(), cl, ca, testLogger())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("expected cmd not to run in Plan mode")
+	}
+	// The pending update is planned instead of denied.
+	if p := status.Sources["base"].Plan; p == nil || !p.Changes {
+		t.Errorf("got plan %v, want changes", p)
+	}
+	for len(recorder.Events) > 0 {
+		if e := <-recorder.Events; strings.Contains(e, "by policy") {
+			t.Errorf("got event %q, want no change denied by policy", e)
+		}
+	}
+}
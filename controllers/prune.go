@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+
+	v1alpha1 "github.com/mmlt/operator-addons/api/v1alpha1"
+	"github.com/mmlt/operator-addons/internal/cluster"
+)
+
+// Labels that tell which ClusterAddon source owns an object in the target cluster.
+const (
+	labelNamespace    = "clusterops.mmlt.nl/namespace"
+	labelClusterAddon = "clusterops.mmlt.nl/clusteraddon"
+	labelSource       = "clusterops.mmlt.nl/source"
+)
+
+// OwnerLabels returns the labels that mark objects as owned by source n of clusterAddon.
+func ownerLabels(clusterAddon *v1alpha1.ClusterAddon, n string) map[string]string {
+	return map[string]string{
+		labelNamespace:    clusterAddon.Namespace,
+		labelClusterAddon: clusterAddon.Name,
+		labelSource:       n,
+	}
+}
+
+// Prune deletes the objects in previous that are not in inventory from the target cluster.
+// It returns the inventory to store; objects that are left in place because of the policy or a failed delete are
+// kept so they are pruned later.
+// The output lists the result of each pruned object like kubectl delete does.
+func prune(cl *cluster.Cluster, policy v1alpha1.ClusterAddonPolicy, previous, inventory []cluster.ObjectRef, owner map[string]string) ([]cluster.ObjectRef, string, error) {
+	if inventory == nil {
+		// Unknown what has been applied so nothing to prune.
+		return nil, "", nil
+	}
+
+	stale := staleObjects(previous, inventory)
+	if len(stale) == 0 {
+		return inventory, "", nil
+	}
+
+	if !allowed(policy, opDelete) {
+		var out strings.Builder
+		for _, ref := range stale {
+			fmt.Fprintf(&out, "%s left in place by policy %s\n", ref, policy)
+		}
+		return append(inventory, stale...), out.String(), nil
+	}
+
+	results, err := cl.Delete(stale, owner)
+	if err != nil {
+		return append(inventory, stale...), "", fmt.Errorf("prune: %w", err)
+	}
+
+	var out strings.Builder
+	var failed int
+	for _, r := range results {
+		if r.Err != nil {
+			fmt.Fprintf(&out, "%s prune failed %v\n", r.Ref, r.Err)
+			inventory = append(inventory, r.Ref)
+			failed++
+			continue
+		}
+		fmt.Fprintf(&out, "%s pruned\n", r.Ref)
+	}
+	if failed > 0 {
+		return inventory, out.String(), fmt.Errorf("prune: %d of %d objects failed to delete", failed, len(results))
+	}
+
+	return inventory, out.String(), nil
+}
+
+// StaleObjects returns the refs in previous that are not in current.
+// The API version is ignored; an object that has moved to another version of its API is the same object.
+func staleObjects(previous, current []cluster.ObjectRef) []cluster.ObjectRef {
+	m := make(map[objectKey]bool, len(current))
+	for _, ref := range current {
+		m[keyOf(ref)] = true
+	}
+
+	var r []cluster.ObjectRef
+	for _, ref := range previous {
+		if !m[keyOf(ref)] {
+			r = append(r, ref)
+		}
+	}
+	return r
+}
+
+// ObjectKey identifies an object independent of the version of its API.
+type objectKey struct {
+	group, kind, namespace, name string
+}
+
+// KeyOf returns the key of ref.
+func keyOf(ref cluster.ObjectRef) objectKey {
+	var group string
+	if i := strings.LastIndex(ref.APIVersion, "/"); i >= 0 {
+		group = ref.APIVersion[:i]
+	}
+	return objectKey{group: group, kind: ref.Kind, namespace: ref.Namespace, name: ref.Name}
+}
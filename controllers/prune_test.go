@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mmlt/operator-addons/api/v1alpha1"
+	"github.com/mmlt/operator-addons/internal/cluster"
+)
+
+func Test_staleObjects(t *testing.T) {
+	a := cluster.ObjectRef{APIVersion: "v1", Kind: "ConfigMap", Namespace: "default", Name: "a"}
+	b := cluster.ObjectRef{APIVersion: "v1", Kind: "ConfigMap", Namespace: "default", Name: "b"}
+	ns := cluster.ObjectRef{APIVersion: "v1", Kind: "Namespace", Name: "x"}
+	deployV1beta2 := cluster.ObjectRef{APIVersion: "apps/v1beta2", Kind: "Deployment", Namespace: "default", Name: "a"}
+	deployV1 := cluster.ObjectRef{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "default", Name: "a"}
+	deployExt := cluster.ObjectRef{APIVersion: "extensions/v1beta1", Kind: "Deployment", Namespace: "default", Name: "a"}
+
+	tests := []struct {
+		name              string
+		previous, current []cluster.ObjectRef
+		want              []cluster.ObjectRef
+	}{
+		{name: "none_previous", current: []cluster.ObjectRef{a}},
+		{name: "same", previous: []cluster.ObjectRef{a, ns}, current: []cluster.ObjectRef{ns, a}},
+		{name: "removed", previous: []cluster.ObjectRef{a, b, ns}, current: []cluster.ObjectRef{a}, want: []cluster.ObjectRef{b, ns}},
+		{name: "api_version_changed", previous: []cluster.ObjectRef{deployV1beta2}, current: []cluster.ObjectRef{deployV1}},
+		{name: "api_group_changed", previous: []cluster.ObjectRef{deployExt}, current: []cluster.ObjectRef{deployV1}, want: []cluster.ObjectRef{deployExt}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := staleObjects(tt.previous, tt.current)
+			if !cmp.Equal(got, tt.want) {
+				t.Errorf("diff (- = got, + = want) %s", cmp.Diff(got, tt.want))
+			}
+		})
+	}
+}
+
+func Test_prune_without_delete(t *testing.T) {
+	a := cluster.ObjectRef{APIVersion: "v1", Kind: "ConfigMap", Namespace: "default", Name: "a"}
+	b := cluster.ObjectRef{APIVersion: "v1", Kind: "ConfigMap", Namespace: "default", Name: "b"}
+
+	tests := []struct {
+		name                string
+		policy              v1alpha1.ClusterAddonPolicy
+		previous, inventory []cluster.ObjectRef
+		want                []cluster.ObjectRef
+		wantOut             string
+	}{
+		{
+			name:     "unknown_inventory",
+			previous: []cluster.ObjectRef{a, b},
+		},
+		{
+			name:      "nothing_stale",
+			previous:  []cluster.ObjectRef{a},
+			inventory: []cluster.ObjectRef{a},
+			want:      []cluster.ObjectRef{a},
+		},
+		{
+			name:      "stale_objects_are_kept_when_denied_by_policy",
+			policy:    v1alpha1.DenyDelete,
+			previous:  []cluster.ObjectRef{a, b},
+			inventory: []cluster.ObjectRef{a},
+			want:      []cluster.ObjectRef{a, b},
+			wantOut:   "ConfigMap/default/b left in place by policy DenyDelete\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A nil cluster makes sure no objects are deleted.
+			got, out, err := prune(nil, tt.policy, tt.previous, tt.inventory, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out != tt.wantOut {
+				t.Errorf("got output %q, want %q", out, tt.wantOut)
+			}
+			if !cmp.Equal(got, tt.want) {
+				t.Errorf("diff (- = got, + = want) %s", cmp.Diff(got, tt.want))
+			}
+		})
+	}
+}
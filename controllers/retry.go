@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"fmt"
+	"time"
+
+	v1alpha1 "github.com/mmlt/operator-addons/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Default backoff of failed actions.
+const (
+	DefaultMinBackoff = 30 * time.Second
+	DefaultMaxBackoff = RequeueDuration
+)
+
+// Revision identifies the source commit and action of a desired state.
+func revision(desired sourceState) string {
+	return fmt.Sprintf("%s/%d", desired.RepoSHA, desired.ActionHash)
+}
+
+// Backoff returns the time to wait before retrying an action that has failed failures times.
+func backoff(policy *v1alpha1.RetryPolicy, failures int) time.Duration {
+	min, max := DefaultMinBackoff, DefaultMaxBackoff
+	if policy != nil {
+		if policy.MinBackoff != nil && policy.MinBackoff.Duration > 0 {
+			min = policy.MinBackoff.Duration
+		}
+		if policy.MaxBackoff != nil && policy.MaxBackoff.Duration > 0 {
+			max = policy.MaxBackoff.Duration
+		}
+	}
+
+	d := min
+	for i := 1; i < failures && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+
+	return d
+}
+
+// RecordFailure counts a failure of the action for rev in ss and sets the time of the next retry.
+func recordFailure(ss *v1alpha1.SourceStatus, policy *v1alpha1.RetryPolicy, rev string, now time.Time) {
+	if ss.FailedRevision != rev {
+		ss.Failures = 0
+	}
+	ss.Failures++
+	ss.FailedRevision = rev
+	ss.NextRetry = nil
+	if policy != nil && policy.MaxFailures > 0 && ss.Failures >= policy.MaxFailures {
+		// Given up.
+		return
+	}
+	t := metav1.NewTime(now.Add(backoff(policy, ss.Failures))).Rfc3339Copy()
+	ss.NextRetry = &t
+}
+
+// ResetFailures clears the failures in ss.
+func resetFailures(ss *v1alpha1.SourceStatus) {
+	ss.Failures = 0
+	ss.FailedRevision = ""
+	ss.NextRetry = nil
+}
+
+// RetryBlocked returns a reason and message when the action for rev must not run yet because it has failed before.
+// An empty reason means the action can run.
+func retryBlocked(ss *v1alpha1.SourceStatus, policy *v1alpha1.RetryPolicy, rev string, now time.Time) (reason, message string) {
+	if ss.Failures == 0 || ss.FailedRevision != rev {
+		return "", ""
+	}
+	if policy != nil && policy.MaxFailures > 0 && ss.Failures >= policy.MaxFailures {
+		return "RetriesExhausted", fmt.Sprintf("failed %d times, not retried until the source or action changes", ss.Failures)
+	}
+	if ss.NextRetry != nil && now.Before(ss.NextRetry.Time) {
+		return "BackOff", fmt.Sprintf("failed %d times, retry at %s", ss.Failures, ss.NextRetry.UTC().Format(time.RFC3339))
+	}
+	return "", ""
+}
+
+// RequeueAfter returns the time until the next reconcile; the first pending retry of a source or RequeueDuration.
+func requeueAfter(status *v1alpha1.ClusterAddonStatus, now time.Time) time.Duration {
+	d := RequeueDuration
+	for _, ss := range status.Sources {
+		if ss.NextRetry == nil {
+			continue
+		}
+		if r := ss.NextRetry.Sub(now); r > 0 && r < d {
+			d = r
+		}
+	}
+
+	return d
+}
@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mmlt/operator-addons/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_backoff(t *testing.T) {
+	policy := &v1alpha1.RetryPolicy{
+		MinBackoff: &metav1.Duration{Duration: 10 * time.Second},
+		MaxBackoff: &metav1.Duration{Duration: time.Minute},
+	}
+	tests := []struct {
+		policy   *v1alpha1.RetryPolicy
+		failures int
+		want     time.Duration
+	}{
+		{policy: nil, failures: 1, want: DefaultMinBackoff},
+		{policy: nil, failures: 2, want: 2 * DefaultMinBackoff},
+		{policy: nil, failures: 100, want: DefaultMaxBackoff},
+		{policy: policy, failures: 1, want: 10 * time.Second},
+		{policy: policy, failures: 3, want: 40 * time.Second},
+		{policy: policy, failures: 4, want: time.Minute},
+	}
+	for _, tt := range tests {
+		if got := backoff(tt.policy, tt.failures); got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func Test_retry(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	policy := &v1alpha1.RetryPolicy{MaxFailures: 2}
+	ss := &v1alpha1.SourceStatus{}
+
+	recordFailure(ss, policy, "sha/1", now)
+
+	if ss.Failures != 1 || ss.NextRetry == nil || !ss.NextRetry.Time.Equal(now.Add(DefaultMinBackoff)) {
+		t.Fatalf("got %d failures and next retry %v", ss.Failures, ss.NextRetry)
+	}
+	if r, _ := retryBlocked(ss, policy, "sha/1", now); r != "BackOff" {
+		t.Errorf("got reason %q, want BackOff", r)
+	}
+	if r, _ := retryBlocked(ss, policy, "sha/1", now.Add(time.Minute)); r != "" {
+		t.Errorf("got reason %q after backoff, want none", r)
+	}
+	if r, _ := retryBlocked(ss, policy, "sha/2", now); r != "" {
+		t.Errorf("got reason %q for changed revision, want none", r)
+	}
+
+	recordFailure(ss, policy, "sha/1", now)
+
+	if ss.NextRetry != nil {
+		t.Errorf("got next retry %v after max failures, want none", ss.NextRetry)
+	}
+	if r, _ := retryBlocked(ss, policy, "sha/1", now.Add(time.Hour)); r != "RetriesExhausted" {
+		t.Errorf("got reason %q, want RetriesExhausted", r)
+	}
+
+	recordFailure(ss, policy, "sha/2", now)
+
+	if ss.Failures != 1 {
+		t.Errorf("got %d failures for changed revision, want 1", ss.Failures)
+	}
+}
+
+func Test_requeueAfter(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	soon := metav1.NewTime(now.Add(time.Minute))
+	past := metav1.NewTime(now.Add(-time.Minute))
+	status := &v1alpha1.ClusterAddonStatus{Sources: map[string]v1alpha1.SourceStatus{
+		"a": {},
+		"b": {NextRetry: &soon},
+		"c": {NextRetry: &past},
+	}}
+
+	if got := requeueAfter(status, now); got != time.Minute {
+		t.Errorf("got %s, want 1m", got)
+	}
+	if got := requeueAfter(&v1alpha1.ClusterAddonStatus{}, now); got != RequeueDuration {
+		t.Errorf("got %s, want %s", got, RequeueDuration)
+	}
+}
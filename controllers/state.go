@@ -2,8 +2,10 @@ package controllers
 
 import (
 	"encoding/json"
+	v1alpha1 "github.com/mmlt/operator-addons/api/v1alpha1"
 	"github.com/mmlt/operator-addons/internal/cluster"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"sort"
 )
 
 // State is the current state of the target cluster.
@@ -16,6 +18,12 @@ type sourceState struct {
 	RepoSHA string
 	// ActionHash is the hash of the last applied action.
 	ActionHash uint64
+	// Source is the last applied source (without credentials).
+	// It is used to teardown the source after it has been removed from the ClusterAddon.
+	Source *v1alpha1.ClusterAddonSource `json:",omitempty"`
+	// Inventory are the objects the last applied action has created or updated.
+	// It is used to prune objects that are no longer applied, nil means the objects are unknown.
+	Inventory []cluster.ObjectRef `json:",omitempty"`
 }
 
 // FieldName in cluster state ConfigMap
@@ -59,6 +67,53 @@ func putState(target *cluster.Cluster, st *state) error {
 	return target.PutState(map[string]string{fieldName: string(b)})
 }
 
+// RemovedSources returns the sorted names of the sources in st that are not in sources.
+func removedSources(st *state, sources map[string]v1alpha1.ClusterAddonSource) []string {
+	var r []string
+	for n := range st.Sources {
+		if _, ok := sources[n]; !ok {
+			r = append(r, n)
+		}
+	}
+	sort.Strings(r)
+	return r
+}
+
+// WithoutCredentials returns a copy of src that is safe to store in the target cluster state.
+// Only the fields teardown needs are copied; tokens, inline files and inline values (that might contain secrets)
+// are left out, values are taken from valuesFrom only.
+func withoutCredentials(src *v1alpha1.ClusterAddonSource) *v1alpha1.ClusterAddonSource {
+	s := &v1alpha1.ClusterAddonSource{
+		Type:        src.Type,
+		URL:         src.URL,
+		Branch:      src.Branch,
+		Tag:         src.Tag,
+		Revision:    src.Revision,
+		SemverRange: src.SemverRange,
+		SHA256:      src.SHA256,
+		Action: v1alpha1.ClusterAddonAction{
+			Type:      src.Action.Type,
+			DeleteCmd: src.Action.DeleteCmd,
+			Path:      src.Action.Path,
+			Release:   src.Action.Release,
+			Namespace: src.Action.Namespace,
+		},
+	}
+	if src.ConfigMapRef != nil {
+		s.ConfigMapRef = src.ConfigMapRef.DeepCopy()
+	}
+	if src.TokenSecretRef != nil {
+		s.TokenSecretRef = src.TokenSecretRef.DeepCopy()
+	}
+	if src.Action.Timeout != nil {
+		s.Action.Timeout = src.Action.Timeout.DeepCopy()
+	}
+	for _, v := range src.Action.ValuesFrom {
+		s.Action.ValuesFrom = append(s.Action.ValuesFrom, *v.DeepCopy())
+	}
+	return s
+}
+
 // IsCode answers true when err is an StatusError with HTTP result code.
 func isCode(err error, code int32) bool {
 	switch t := err.(type) {
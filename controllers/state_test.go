@@ -0,0 +1,83 @@
+package controllers
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mmlt/operator-addons/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func Test_removedSources(t *testing.T) {
+	st := &state{Sources: map[string]sourceState{
+		"base":    {},
+		"ingress": {},
+		"old":     {},
+	}}
+	sources := map[string]v1alpha1.ClusterAddonSource{
+		"base":    {},
+		"ingress": {},
+		"new":     {},
+	}
+
+	got := removedSources(st, sources)
+	want := []string{"old"}
+	if !cmp.Equal(got, want) {
+		t.Errorf("diff (- = got, + = want) %s", cmp.Diff(got, want))
+	}
+}
+
+func Test_withoutCredentials(t *testing.T) {
+	src := &v1alpha1.ClusterAddonSource{
+		URL:            "https://github.com/mmlt/example-cluster-addons",
+		Branch:         "master",
+		Token:          "secret",
+		TokenSecretRef: &v1alpha1.SecretKeyReference{Name: "git", Key: "token"},
+		Files:          map[string]string{"a.yaml": "password: secret"},
+		Action: v1alpha1.ClusterAddonAction{
+			Cmd:        "true",
+			DeleteCmd:  "./delete.sh",
+			Path:       "deploy",
+			Values:     &runtime.RawExtension{Raw: []byte(`{"password":"secret"}`)},
+			ValuesFrom: []v1alpha1.ValuesFromSource{{SecretRef: &corev1.LocalObjectReference{Name: "values"}}},
+		},
+	}
+
+	got := withoutCredentials(src)
+
+	want := &v1alpha1.ClusterAddonSource{
+		URL:            "https://github.com/mmlt/example-cluster-addons",
+		Branch:         "master",
+		TokenSecretRef: &v1alpha1.SecretKeyReference{Name: "git", Key: "token"},
+		Action: v1alpha1.ClusterAddonAction{
+			DeleteCmd:  "./delete.sh",
+			Path:       "deploy",
+			ValuesFrom: []v1alpha1.ValuesFromSource{{SecretRef: &corev1.LocalObjectReference{Name: "values"}}},
+		},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("diff (- = got, + = want) %s", diff)
+	}
+	if src.Token != "secret" || src.Action.Values == nil {
+		t.Errorf("original source has been modified")
+	}
+}
+
+func Test_state_unmarshal_without_source(t *testing.T) {
+	// State as written by previous versions of the operator.
+	b := `{"Sources":{"base":{"RepoSHA":"a3a053fb28df45e33db1b634c1a45cb76e3d8bdf","ActionHash":1}}}`
+
+	var st state
+	err := json.Unmarshal([]byte(b), &st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := state{Sources: map[string]sourceState{
+		"base": {RepoSHA: "a3a053fb28df45e33db1b634c1a45cb76e3d8bdf", ActionHash: 1},
+	}}
+	if !cmp.Equal(st, want) {
+		t.Errorf("diff (- = got, + = want) %s", cmp.Diff(st, want))
+	}
+}
@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	v1alpha1 "github.com/mmlt/operator-addons/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// ValuesFor returns the values of an action with the ValuesFrom ConfigMaps and Secrets in namespace merged in.
+// ConfigMap and Secret keys become top-level values, top-level inline Values take precedence.
+// The top-level values that come from Secrets are returned as secret keys.
+func (r *ClusterAddonReconciler) valuesFor(ctx context.Context, namespace string, action *v1alpha1.ClusterAddonAction) (map[string]interface{}, map[string]bool, error) {
+	values, err := decodeValues(action.Values)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(action.ValuesFrom) == 0 {
+		return values, nil, nil
+	}
+
+	var sources []map[string]interface{}
+	secretKeys := map[string]bool{}
+	for _, vf := range action.ValuesFrom {
+		data, err := r.valuesFromSource(ctx, namespace, &vf)
+		if err != nil {
+			return nil, nil, err
+		}
+		src := withPrefix(vf.Prefix, data)
+		for k := range src {
+			secretKeys[k] = vf.SecretRef != nil
+		}
+		sources = append(sources, src)
+	}
+	sources = append(sources, values)
+	for k := range values {
+		secretKeys[k] = false
+	}
+	for k, secret := range secretKeys {
+		if !secret {
+			delete(secretKeys, k)
+		}
+	}
+
+	return mergeValues(sources...), secretKeys, nil
+}
+
+// DecodeValues returns the structure of raw values.
+func decodeValues(raw *runtime.RawExtension) (map[string]interface{}, error) {
+	if raw == nil || len(raw.Raw) == 0 {
+		return nil, nil
+	}
+	var values map[string]interface{}
+	err := json.Unmarshal(raw.Raw, &values)
+	if err != nil {
+		return nil, fmt.Errorf("values: an object expected: %w", err)
+	}
+	return values, nil
+}
+
+// ValuesFromSource returns the data of the ConfigMap or Secret selected by vf.
+// An optional ConfigMap or Secret that doesn't exist results in empty data.
+func (r *ClusterAddonReconciler) valuesFromSource(ctx context.Context, namespace string, vf *v1alpha1.ValuesFromSource) (map[string]string, error) {
+	var err error
+	data := map[string]string{}
+	switch {
+	case vf.ConfigMapRef != nil:
+		cm := &corev1.ConfigMap{}
+		err = r.Get(ctx, types.NamespacedName{Namespace: namespace, Name: vf.ConfigMapRef.Name}, cm)
+		for k, v := range cm.Data {
+			data[k] = v
+		}
+	case vf.SecretRef != nil:
+		secret := &corev1.Secret{}
+		err = r.Get(ctx, types.NamespacedName{Namespace: namespace, Name: vf.SecretRef.Name}, secret)
+		for k, v := range secret.Data {
+			data[k] = string(v)
+		}
+	default:
+		return nil, fmt.Errorf("valuesFrom: configMapRef or secretRef expected")
+	}
+	if err != nil {
+		if vf.Optional && ignoreNotFound(err) == nil {
+			return data, nil
+		}
+		return nil, fmt.Errorf("valuesFrom: %w", err)
+	}
+
+	return data, nil
+}
+
+// WithPrefix returns data with prefix prepended to each key.
+func withPrefix(prefix string, data map[string]string) map[string]interface{} {
+	r := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		r[prefix+k] = v
+	}
+	return r
+}
+
+// MergeValues merges sources into a new map, top-level values of later sources overwrite values of earlier sources.
+func mergeValues(sources ...map[string]interface{}) map[string]interface{} {
+	r := map[string]interface{}{}
+	for _, src := range sources {
+		for k, v := range src {
+			r[k] = v
+		}
+	}
+	return r
+}
@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mmlt/operator-addons/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func Test_valuesFor(t *testing.T) {
+	r := testReconciler(
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "env"},
+			Data:       map[string]string{"domain": "example.com", "zone": "from-configmap"},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "registry"},
+			Data:       map[string][]byte{"password": []byte("secret"), "zone": []byte("from-secret")},
+		},
+	)
+
+	tests := []struct {
+		name       string
+		action     v1alpha1.ClusterAddonAction
+		want       map[string]interface{}
+		wantSecret []string
+		wantErr    bool
+	}{
+		{
+			name:   "values_only",
+			action: v1alpha1.ClusterAddonAction{Values: raw(`{"a": "b"}`)},
+			want:   map[string]interface{}{"a": "b"},
+		},
+		{
+			name: "later_sources_and_values_take_precedence",
+			action: v1alpha1.ClusterAddonAction{
+				Values: raw(`{"domain": "from-values"}`),
+				ValuesFrom: []v1alpha1.ValuesFromSource{
+					{ConfigMapRef: &corev1.LocalObjectReference{Name: "env"}},
+					{SecretRef: &corev1.LocalObjectReference{Name: "registry"}},
+				},
+			},
+			want:       map[string]interface{}{"domain": "from-values", "zone": "from-secret", "password": "secret"},
+			wantSecret: []string{"password", "zone"},
+		},
+		{
+			name: "prefix",
+			action: v1alpha1.ClusterAddonAction{
+				ValuesFrom: []v1alpha1.ValuesFromSource{
+					{ConfigMapRef: &corev1.LocalObjectReference{Name: "env"}, Prefix: "env_"},
+				},
+			},
+			want: map[string]interface{}{"env_domain": "example.com", "env_zone": "from-configmap"},
+		},
+		{
+			name: "optional_missing",
+			action: v1alpha1.ClusterAddonAction{
+				Values: raw(`{"a": "b"}`),
+				ValuesFrom: []v1alpha1.ValuesFromSource{
+					{ConfigMapRef: &corev1.LocalObjectReference{Name: "missing"}, Optional: true},
+				},
+			},
+			want: map[string]interface{}{"a": "b"},
+		},
+		{
+			name:   "structured_values",
+			action: v1alpha1.ClusterAddonAction{Values: raw(`{"a": {"b": ["x"]}, "n": 1}`)},
+			want:   map[string]interface{}{"a": map[string]interface{}{"b": []interface{}{"x"}}, "n": 1.0},
+		},
+		{
+			name:    "values_not_an_object",
+			action:  v1alpha1.ClusterAddonAction{Values: raw(`["x"]`)},
+			wantErr: true,
+		},
+		{
+			name: "required_missing",
+			action: v1alpha1.ClusterAddonAction{
+				ValuesFrom: []v1alpha1.ValuesFromSource{
+					{SecretRef: &corev1.LocalObjectReference{Name: "missing"}},
+				},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, secretKeys, err := r.valuesFor(context.Background(), "ns", &tt.action)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if !cmp.Equal(got, tt.want) {
+				t.Errorf("diff (- = got, + = want) %s", cmp.Diff(got, tt.want))
+			}
+			var gotSecret []string
+			for k := range secretKeys {
+				gotSecret = append(gotSecret, k)
+			}
+			sort.Strings(gotSecret)
+			if !cmp.Equal(gotSecret, tt.wantSecret) {
+				t.Errorf("secret keys diff (- = got, + = want) %s", cmp.Diff(gotSecret, tt.wantSecret))
+			}
+		})
+	}
+}
+
+// Raw returns s as RawExtension.
+func raw(s string) *runtime.RawExtension {
+	return &runtime.RawExtension{Raw: []byte(s)}
+}
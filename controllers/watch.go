@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"context"
+
+	v1alpha1 "github.com/mmlt/operator-addons/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// RequestsFor returns a function that maps an object to reconcile requests for the ClusterAddons that refer to it.
+// Names returns the names of the objects (of the watched kind) a ClusterAddon refers to.
+func (r *ClusterAddonReconciler) requestsFor(names func(*v1alpha1.ClusterAddon) []string) handler.ToRequestsFunc {
+	return func(o handler.MapObject) []reconcile.Request {
+		list := &v1alpha1.ClusterAddonList{}
+		err := r.List(context.Background(), list, client.InNamespace(o.Meta.GetNamespace()))
+		if err != nil {
+			r.Log.Error(err, "List ClusterAddons", "name", o.Meta.GetName())
+			return nil
+		}
+
+		var result []reconcile.Request
+		for _, ca := range list.Items {
+			if containsString(names(&ca), o.Meta.GetName()) {
+				result = append(result, reconcile.Request{
+					NamespacedName: types.NamespacedName{Namespace: ca.Namespace, Name: ca.Name},
+				})
+			}
+		}
+
+		return result
+	}
+}
+
+// SecretNames returns the names of the Secrets a ClusterAddon refers to.
+func secretNames(clusterAddon *v1alpha1.ClusterAddon) []string {
+	var names []string
+
+	target := &clusterAddon.Spec.Target
+	if target.SecretRef != nil {
+		names = append(names, target.SecretRef.Name)
+	}
+	if target.KubeconfigSecretRef != nil {
+		names = append(names, target.KubeconfigSecretRef.Name)
+	}
+
+	for _, src := range clusterAddon.Spec.Sources {
+		if src.TokenSecretRef != nil {
+			names = append(names, src.TokenSecretRef.Name)
+		}
+		for _, vf := range src.Action.ValuesFrom {
+			if vf.SecretRef != nil {
+				names = append(names, vf.SecretRef.Name)
+			}
+		}
+	}
+
+	return names
+}
+
+// ConfigMapNames returns the names of the ConfigMaps a ClusterAddon refers to.
+func configMapNames(clusterAddon *v1alpha1.ClusterAddon) []string {
+	var names []string
+
+	for _, src := range clusterAddon.Spec.Sources {
+		if src.Type == v1alpha1.SourceTypeConfigMap && src.ConfigMapRef != nil {
+			names = append(names, src.ConfigMapRef.Name)
+		}
+		for _, vf := range src.Action.ValuesFrom {
+			if vf.ConfigMapRef != nil {
+				names = append(names, vf.ConfigMapRef.Name)
+			}
+		}
+	}
+
+	return names
+}
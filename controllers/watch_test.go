@@ -0,0 +1,99 @@
+package controllers
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/mmlt/operator-addons/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// TestReconciler returns a reconciler with a fake client that contains objs.
+func testReconciler(objs ...runtime.Object) *ClusterAddonReconciler {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = v1alpha1.AddToScheme(scheme)
+
+	return &ClusterAddonReconciler{
+		Client: fake.NewFakeClientWithScheme(scheme, objs...),
+		Log:    testLogger(),
+		Scheme: scheme,
+	}
+}
+
+func testLogger() logr.Logger {
+	return zap.Logger(true)
+}
+
+func Test_requestsFor_secret(t *testing.T) {
+	r := testReconciler(
+		&v1alpha1.ClusterAddon{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "by-secret"},
+			Spec: v1alpha1.ClusterAddonSpec{Target: v1alpha1.ClusterAddonTarget{
+				SecretRef: &corev1.LocalObjectReference{Name: "creds"},
+			}},
+		},
+		&v1alpha1.ClusterAddon{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "by-kubeconfig"},
+			Spec: v1alpha1.ClusterAddonSpec{Target: v1alpha1.ClusterAddonTarget{
+				KubeconfigSecretRef: &v1alpha1.SecretKeyReference{Name: "creds", Key: "value"},
+			}},
+		},
+		&v1alpha1.ClusterAddon{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "other"},
+			Spec: v1alpha1.ClusterAddonSpec{Target: v1alpha1.ClusterAddonTarget{
+				SecretRef: &corev1.LocalObjectReference{Name: "other"},
+			}},
+		},
+		&v1alpha1.ClusterAddon{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "other-ns", Name: "by-secret"},
+			Spec: v1alpha1.ClusterAddonSpec{Target: v1alpha1.ClusterAddonTarget{
+				SecretRef: &corev1.LocalObjectReference{Name: "creds"},
+			}},
+		},
+	)
+
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "creds"}}
+	got := r.requestsFor(secretNames)(handler.MapObject{Meta: secret, Object: secret})
+	sort.Slice(got, func(i, j int) bool { return got[i].Name < got[j].Name })
+
+	want := []reconcile.Request{
+		{NamespacedName: types.NamespacedName{Namespace: "ns", Name: "by-kubeconfig"}},
+		{NamespacedName: types.NamespacedName{Namespace: "ns", Name: "by-secret"}},
+	}
+	if !cmp.Equal(got, want) {
+		t.Errorf("diff (- = got, + = want) %s", cmp.Diff(got, want))
+	}
+}
+
+func Test_configMapNames(t *testing.T) {
+	ca := &v1alpha1.ClusterAddon{Spec: v1alpha1.ClusterAddonSpec{Sources: map[string]v1alpha1.ClusterAddonSource{
+		"files": {
+			Type:         v1alpha1.SourceTypeConfigMap,
+			ConfigMapRef: &corev1.LocalObjectReference{Name: "content"},
+		},
+		"values": {
+			Action: v1alpha1.ClusterAddonAction{ValuesFrom: []v1alpha1.ValuesFromSource{
+				{ConfigMapRef: &corev1.LocalObjectReference{Name: "values"}},
+			}},
+		},
+	}}}
+
+	got := configMapNames(ca)
+	sort.Strings(got)
+
+	want := []string{"content", "values"}
+	if !cmp.Equal(got, want) {
+		t.Errorf("diff (- = got, + = want) %s", cmp.Diff(got, want))
+	}
+}
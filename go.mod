@@ -3,18 +3,22 @@ module github.com/mmlt/operator-addons
 go 1.13
 
 require (
-	github.com/ghodss/yaml v0.0.0-20150909031657-73d445a93680
+	github.com/Masterminds/semver/v3 v3.0.3
+	github.com/Masterminds/sprig/v3 v3.0.2
+	github.com/ghodss/yaml v1.0.0
 	github.com/go-logr/glogr v0.1.0
 	github.com/go-logr/logr v0.1.0
-	github.com/google/go-cmp v0.3.0
+	github.com/google/go-cmp v0.3.1
 	github.com/mitchellh/hashstructure v1.0.0
-	github.com/onsi/ginkgo v1.8.0
-	github.com/onsi/gomega v1.5.0
-	github.com/stretchr/testify v1.3.0
-	gopkg.in/yaml.v2 v2.2.2
+	github.com/modern-go/reflect2 v1.0.2 // indirect
+	github.com/onsi/ginkgo v1.10.1
+	github.com/onsi/gomega v1.7.0
+	github.com/stretchr/testify v1.4.0
+	gopkg.in/yaml.v2 v2.2.4
 	k8s.io/api v0.0.0-20190918155943-95b840bb6a1f
 	k8s.io/apimachinery v0.0.0-20190913080033-27d36303b655
 	k8s.io/client-go v0.0.0-20190918160344-1fbdaa4c8d90
 	sigs.k8s.io/controller-runtime v0.4.0
+	sigs.k8s.io/kustomize/api v0.2.0
 	sigs.k8s.io/yaml v1.1.0
 )
@@ -0,0 +1,82 @@
+// Unpack archives of add-on content.
+package archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ExtractTarGz extracts the gzipped tar archive read from r into dir.
+// Entries that would end up outside dir are rejected, entries other than directories and regular files are skipped.
+func ExtractTarGz(r io.Reader, dir string) error {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("gzip: %w", err)
+	}
+	defer zr.Close()
+
+	return ExtractTar(zr, dir)
+}
+
+// ExtractTar extracts the tar archive read from r into dir.
+func ExtractTar(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("tar: %w", err)
+		}
+
+		p, err := target(dir, h.Name)
+		if err != nil {
+			return err
+		}
+
+		switch h.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(p, 0755)
+		case tar.TypeReg, tar.TypeRegA:
+			err = writeFile(p, tr, os.FileMode(h.Mode).Perm()|0600)
+		default:
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Target returns the path of archive entry name in dir.
+func target(dir, name string) (string, error) {
+	p := filepath.Join(dir, filepath.FromSlash(name))
+	if p != filepath.Clean(dir) && !strings.HasPrefix(p, filepath.Clean(dir)+string(filepath.Separator)) {
+		return "", fmt.Errorf("archive entry %s is outside the target directory", name)
+	}
+	return p, nil
+}
+
+// WriteFile writes the content read from r to a file at p.
+func writeFile(p string, r io.Reader, mode os.FileMode) error {
+	err := os.MkdirAll(filepath.Dir(p), 0755)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,59 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractTarGz(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	err = ExtractTarGz(testTarGz(t, map[string]string{
+		"deploy/a.yaml": "kind: A",
+		"run.sh":        "echo hi",
+	}), dir)
+
+	require.NoError(t, err)
+	b, err := ioutil.ReadFile(filepath.Join(dir, "deploy", "a.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, "kind: A", string(b))
+	assert.FileExists(t, filepath.Join(dir, "run.sh"))
+}
+
+func TestExtractTarGz_outside(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	err = ExtractTarGz(testTarGz(t, map[string]string{"../evil": "x"}), dir)
+
+	assert.Error(t, err)
+	_, err = os.Stat(filepath.Join(filepath.Dir(dir), "evil"))
+	assert.True(t, os.IsNotExist(err), "file outside target directory")
+}
+
+// TestTarGz returns a gzipped tar archive with files (path -> content).
+func testTarGz(t *testing.T, files map[string]string) *bytes.Buffer {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	for p, s := range files {
+		err := tw.WriteHeader(&tar.Header{Name: p, Mode: 0644, Size: int64(len(s)), Typeflag: tar.TypeReg})
+		require.NoError(t, err)
+		_, err = tw.Write([]byte(s))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, zw.Close())
+	return &buf
+}
@@ -0,0 +1,195 @@
+package cluster
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/restmapper"
+)
+
+// FieldManager is the manager of the fields that are applied to the target cluster.
+const FieldManager = "op-addons"
+
+// ObjectRef refers to an object in the target cluster.
+type ObjectRef struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	// Namespace is empty for cluster scoped objects.
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+}
+
+// RefOf returns a reference to obj.
+func RefOf(obj *unstructured.Unstructured) ObjectRef {
+	return ObjectRef{
+		APIVersion: obj.GetAPIVersion(),
+		Kind:       obj.GetKind(),
+		Namespace:  obj.GetNamespace(),
+		Name:       obj.GetName(),
+	}
+}
+
+// String returns the reference in kind/namespace/name format.
+func (r ObjectRef) String() string {
+	if r.Namespace == "" {
+		return r.Kind + "/" + r.Name
+	}
+	return r.Kind + "/" + r.Namespace + "/" + r.Name
+}
+
+// ApplyResult is the result of applying an object.
+type ApplyResult struct {
+	// Object is the object that has been applied.
+	Object *unstructured.Unstructured
+	// Err is the reason the apply failed (nil on success).
+	Err error
+}
+
+// DeleteResult is the result of deleting an object.
+type DeleteResult struct {
+	// Ref is the object that has been deleted.
+	Ref ObjectRef
+	// Err is the reason the delete failed (nil on success).
+	Err error
+}
+
+// Apply applies objs to the target cluster with server-side apply.
+// Objects are applied in order, objects without namespace get the namespace 'default' when namespaced.
+// Conflicting field managers are overruled.
+// An error is returned when the target cluster can't be accessed, otherwise the results tell how each object fared.
+func (c *Cluster) Apply(objs []*unstructured.Unstructured) ([]ApplyResult, error) {
+	client, mapper, err := c.dynamic()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]ApplyResult, 0, len(objs))
+	for _, obj := range objs {
+		err := c.apply(client, mapper, obj)
+		results = append(results, ApplyResult{Object: obj, Err: err})
+	}
+
+	return results, nil
+}
+
+// Apply applies a single object.
+func (c *Cluster) apply(client dynamic.Interface, mapper *restmapper.DeferredDiscoveryRESTMapper, obj *unstructured.Unstructured) error {
+	ri, namespace, err := resourceFor(client, mapper, obj.GroupVersionKind(), obj.GetNamespace())
+	if err != nil {
+		return err
+	}
+	obj.SetNamespace(namespace)
+
+	data, err := obj.MarshalJSON()
+	if err != nil {
+		return err
+	}
+
+	force := true
+	_, err = ri.Patch(obj.GetName(), types.ApplyPatchType, data, metav1.PatchOptions{FieldManager: FieldManager, Force: &force})
+	if err != nil {
+		return fmt.Errorf("apply: %w", err)
+	}
+	c.log.V(2).Info("Apply", "kind", obj.GetKind(), "namespace", namespace, "name", obj.GetName())
+
+	return nil
+}
+
+// Delete deletes the objects refs refers to from the target cluster in reverse order.
+// Objects that don't exist are considered deleted.
+// Objects that have one of the owner label keys with a different value belong to someone else and are left in place.
+// An error is returned when the target cluster can't be accessed, otherwise the results tell how each object fared.
+func (c *Cluster) Delete(refs []ObjectRef, owner map[string]string) ([]DeleteResult, error) {
+	client, mapper, err := c.dynamic()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]DeleteResult, 0, len(refs))
+	for i := len(refs) - 1; i >= 0; i-- {
+		err := c.delete(client, mapper, refs[i], owner)
+		results = append(results, DeleteResult{Ref: refs[i], Err: err})
+	}
+
+	return results, nil
+}
+
+// Delete deletes a single object.
+func (c *Cluster) delete(client dynamic.Interface, mapper *restmapper.DeferredDiscoveryRESTMapper, ref ObjectRef, owner map[string]string) error {
+	ri, _, err := resourceFor(client, mapper, schema.FromAPIVersionAndKind(ref.APIVersion, ref.Kind), ref.Namespace)
+	if meta.IsNoMatchError(err) {
+		// The kind doesn't exist (anymore) so neither does the object.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	obj, err := ri.Get(ref.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	labels := obj.GetLabels()
+	for k, v := range owner {
+		if l, ok := labels[k]; ok && l != v {
+			return fmt.Errorf("delete: owned by %s=%s", k, l)
+		}
+	}
+
+	policy := metav1.DeletePropagationBackground
+	err = ri.Delete(ref.Name, &metav1.DeleteOptions{PropagationPolicy: &policy})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("delete: %w", err)
+	}
+	c.log.V(2).Info("Delete", "kind", ref.Kind, "namespace", ref.Namespace, "name", ref.Name)
+
+	return nil
+}
+
+// Dynamic returns a dynamic client and a REST mapper for the target cluster.
+func (c *Cluster) dynamic() (dynamic.Interface, *restmapper.DeferredDiscoveryRESTMapper, error) {
+	dc, err := discovery.NewDiscoveryClientForConfig(c.config)
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := dynamic.NewForConfig(c.config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return client, restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(dc)), nil
+}
+
+// ResourceFor returns the client for objects of kind gvk in namespace.
+// The returned namespace is 'default' when the kind is namespaced and namespace is empty, it's empty for cluster
+// scoped kinds.
+func resourceFor(client dynamic.Interface, mapper *restmapper.DeferredDiscoveryRESTMapper, gvk schema.GroupVersionKind, namespace string) (dynamic.ResourceInterface, string, error) {
+	mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if meta.IsNoMatchError(err) {
+		// The kind might have been added by a CRD that has been applied recently.
+		mapper.Reset()
+		mapping, err = mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		return client.Resource(mapping.Resource), "", nil
+	}
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+	}
+	return client.Resource(mapping.Resource).Namespace(namespace), namespace, nil
+}
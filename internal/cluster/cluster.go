@@ -2,7 +2,9 @@
 package cluster
 
 import (
+	"context"
 	"crypto/tls"
+	"fmt"
 	"github.com/ghodss/yaml"
 	"github.com/go-logr/logr"
 	"github.com/mmlt/operator-addons/internal/exe"
@@ -11,6 +13,7 @@ import (
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/tools/clientcmd/api"
 	"net"
@@ -33,6 +36,8 @@ type Cluster struct {
 	// LastUpdate the cluster is reconciled.
 	LastUpdate time.Time
 
+	// Config is the client config of the target cluster.
+	config *rest.Config
 	// Client is used to communicate with the target cluster.
 	client *kubernetes.Clientset
 	// Log is cluster specific logger.
@@ -117,13 +122,44 @@ func (c *Cluster) SetServerCoordinates(url string, serverCA []byte, user, passwo
 		CurrentContext: "default",
 	}
 
+	return c.setConfig(kc)
+}
+
+// SetKubeconfig sets all parameters to access a target API Server from a kubeconfig.
+// The API Server is the server of the kubeconfig current-context.
+func (c *Cluster) SetKubeconfig(data []byte) error {
+	kc, err := clientcmd.Load(data)
+	if err != nil {
+		return err
+	}
+
+	ctx, ok := kc.Contexts[kc.CurrentContext]
+	if !ok {
+		return fmt.Errorf("kubeconfig current-context %q not found", kc.CurrentContext)
+	}
+	cl, ok := kc.Clusters[ctx.Cluster]
+	if !ok {
+		return fmt.Errorf("kubeconfig cluster %q not found", ctx.Cluster)
+	}
+	c.Server = cl.Server
+
+	return c.setConfig(*kc)
+}
+
+// Kubeconfig returns the kubeconfig to access the target cluster.
+func (c *Cluster) Kubeconfig() ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(c.Path, ".kube", "config"))
+}
+
+// SetConfig writes kc to $HOME/.kube/config and creates a client from it.
+func (c *Cluster) setConfig(kc api.Config) error {
 	d, err := clientcmd.Write(kc)
 	if err != nil {
 		return err
 	}
 
 	p := filepath.Join(c.Path, ".kube", "config")
-	err = ioutil.WriteFile(p, d, 0755)
+	err = writePrivateFile(p, d)
 	if err != nil {
 		return err
 	}
@@ -142,6 +178,7 @@ func (c *Cluster) SetServerCoordinates(url string, serverCA []byte, user, passwo
 	}
 	c.log.V(3).Info("Created client")
 
+	c.config = config
 	c.client = client
 
 	return nil
@@ -216,10 +253,12 @@ func (c *Cluster) PutState(data map[string]string) error {
 //		.kube/config
 //		values.yaml
 //
-func (c *Cluster) RunShell(cmd string, values interface{}, extraEnv []string) error {
+// RunShell returns the combined stdout and stderr of cmd, also when cmd fails.
+// When ctx is done before cmd completes cmd is killed.
+func (c *Cluster) RunShell(ctx context.Context, cmd string, values interface{}, extraEnv []string) (string, error) {
 	err := c.writeValuesYaml(values)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	// Collect environment variables
@@ -232,12 +271,7 @@ func (c *Cluster) RunShell(cmd string, values interface{}, extraEnv []string) er
 		Env: env,
 	}
 
-	_, _, err = exe.Run("bash", exe.Args{"-c", cmd}, opt, c.log)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return exe.RunCombined(ctx, "bash", exe.Args{"-c", cmd}, opt, c.log)
 }
 
 // WriteValuesYaml write a values.yaml file with 'data' in $HOME
@@ -248,7 +282,7 @@ func (c *Cluster) writeValuesYaml(data interface{}) error {
 	}
 
 	p := filepath.Join(c.Path, "values.yaml")
-	err = ioutil.WriteFile(p, d, 0755)
+	err = writePrivateFile(p, d)
 	if err != nil {
 		return err
 	}
@@ -256,3 +290,13 @@ func (c *Cluster) writeValuesYaml(data interface{}) error {
 
 	return nil
 }
+
+// WritePrivateFile writes data to a file at path p that is only readable by the operator.
+// The mode of an existing file is changed because it may have been written with a wider mode.
+func writePrivateFile(p string, data []byte) error {
+	err := ioutil.WriteFile(p, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Chmod(p, 0600)
+}
@@ -0,0 +1,33 @@
+package cluster
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+func TestRunShell_writes_private_files(t *testing.T) {
+	c, err := New("cluster-test", zap.Logger(true))
+	assert.NoError(t, err)
+	defer os.RemoveAll(c.Path)
+	// A file of an earlier version of the operator.
+	err = ioutil.WriteFile(filepath.Join(c.Path, "values.yaml"), nil, 0755)
+	assert.NoError(t, err)
+	err = c.SetServerCoordinates("https://127.0.0.1:6443", nil, "user", "password", nil, nil)
+	assert.NoError(t, err)
+
+	_, err = c.RunShell(context.Background(), "true", map[string]interface{}{"secret": "x"}, nil)
+	assert.NoError(t, err)
+
+	for _, p := range []string{"values.yaml", ".kube/config"} {
+		fi, err := os.Stat(filepath.Join(c.Path, p))
+		if assert.NoError(t, err) {
+			assert.Equal(t, os.FileMode(0600), fi.Mode().Perm(), p)
+		}
+	}
+}
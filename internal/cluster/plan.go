@@ -0,0 +1,145 @@
+package cluster
+
+import (
+	"fmt"
+
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/restmapper"
+)
+
+// Changes an apply would make to an object.
+const (
+	ChangeCreated    = "created"
+	ChangeConfigured = "configured"
+	ChangeUnchanged  = "unchanged"
+)
+
+// PlanResult is the result of planning the apply of an object.
+type PlanResult struct {
+	// Object is the object that has been planned.
+	Object *unstructured.Unstructured
+	// Change is the change an apply would make; ChangeCreated, ChangeConfigured or ChangeUnchanged.
+	Change string
+	// Diff shows the changes to the live object (- = live, + = applied), empty when the object is created.
+	Diff string
+	// Err is the reason the plan failed (nil on success).
+	Err error
+}
+
+// Plan applies objs to the target cluster with a server-side dry-run and returns the changes an Apply would make.
+// An error is returned when the target cluster can't be accessed, otherwise the results tell how each object fared.
+func (c *Cluster) Plan(objs []*unstructured.Unstructured) ([]PlanResult, error) {
+	client, mapper, err := c.dynamic()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]PlanResult, 0, len(objs))
+	for _, obj := range objs {
+		results = append(results, c.plan(client, mapper, obj))
+	}
+
+	return results, nil
+}
+
+// Plan plans a single object.
+func (c *Cluster) plan(client dynamic.Interface, mapper *restmapper.DeferredDiscoveryRESTMapper, obj *unstructured.Unstructured) PlanResult {
+	r := PlanResult{Object: obj}
+
+	ri, namespace, err := resourceFor(client, mapper, obj.GroupVersionKind(), obj.GetNamespace())
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	obj.SetNamespace(namespace)
+
+	data, err := obj.MarshalJSON()
+	if err != nil {
+		r.Err = err
+		return r
+	}
+
+	live, err := ri.Get(obj.GetName(), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		live = nil
+	} else if err != nil {
+		r.Err = fmt.Errorf("get: %w", err)
+		return r
+	}
+
+	force := true
+	applied, err := ri.Patch(obj.GetName(), types.ApplyPatchType, data,
+		metav1.PatchOptions{FieldManager: FieldManager, Force: &force, DryRun: []string{metav1.DryRunAll}})
+	if err != nil {
+		r.Err = fmt.Errorf("dry-run apply: %w", err)
+		return r
+	}
+	c.log.V(2).Info("Plan", "kind", obj.GetKind(), "namespace", namespace, "name", obj.GetName())
+
+	if live == nil {
+		r.Change = ChangeCreated
+		return r
+	}
+	r.Diff = diff(live, applied)
+	r.Change = ChangeUnchanged
+	if r.Diff != "" {
+		r.Change = ChangeConfigured
+	}
+
+	return r
+}
+
+// Diff returns the differences between the live and the applied object, the fields that are maintained by the
+// API Server are ignored.
+// The values of Secrets are masked like kubectl diff does.
+func diff(live, applied *unstructured.Unstructured) string {
+	l, a := userFields(live), userFields(applied)
+	if live.GetKind() == "Secret" && live.GroupVersionKind().Group == "" {
+		for _, f := range []string{"data", "stringData"} {
+			maskSecret(l, a, f)
+		}
+	}
+	return cmp.Diff(l, a)
+}
+
+// MaskSecret replaces the values of field f (data or stringData) of the live and applied Secret by '***'.
+// Values that differ are masked as '*** (before)' and '*** (after)' so the diff still shows what has changed.
+func maskSecret(live, applied map[string]interface{}, f string) {
+	lv, _, _ := unstructured.NestedMap(live, f)
+	av, _, _ := unstructured.NestedMap(applied, f)
+	for k, v := range lv {
+		if w, ok := av[k]; ok && w == v {
+			lv[k] = "***"
+		} else {
+			lv[k] = "*** (before)"
+		}
+	}
+	for k := range av {
+		if lv[k] == "***" {
+			av[k] = "***"
+		} else {
+			av[k] = "*** (after)"
+		}
+	}
+	if lv != nil {
+		_ = unstructured.SetNestedMap(live, lv, f)
+	}
+	if av != nil {
+		_ = unstructured.SetNestedMap(applied, av, f)
+	}
+}
+
+// UserFields returns the content of obj without status and the metadata that is maintained by the API Server.
+func userFields(obj *unstructured.Unstructured) map[string]interface{} {
+	o := obj.DeepCopy().Object
+	for _, f := range []string{"managedFields", "resourceVersion", "generation", "creationTimestamp", "uid", "selfLink"} {
+		unstructured.RemoveNestedField(o, "metadata", f)
+	}
+	unstructured.RemoveNestedField(o, "status")
+	return o
+}
@@ -0,0 +1,48 @@
+package cluster
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestDiff(t *testing.T) {
+	cm := func(value, resourceVersion string) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata": map[string]interface{}{
+				"name":            "a",
+				"resourceVersion": resourceVersion,
+			},
+			"data": map[string]interface{}{"key": value},
+		}}
+	}
+
+	assert.Empty(t, diff(cm("x", "1"), cm("x", "2")), "server maintained fields are ignored")
+
+	d := diff(cm("x", "1"), cm("y", "1"))
+	assert.Contains(t, d, `"x"`)
+	assert.Contains(t, d, `"y"`)
+}
+
+func TestDiff_secret(t *testing.T) {
+	secret := func(data map[string]interface{}) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Secret",
+			"metadata":   map[string]interface{}{"name": "a"},
+			"data":       data,
+		}}
+	}
+
+	assert.Empty(t, diff(secret(map[string]interface{}{"key": "c2VjcmV0"}), secret(map[string]interface{}{"key": "c2VjcmV0"})))
+
+	d := diff(secret(map[string]interface{}{"same": "c2FtZQ==", "key": "c2VjcmV0"}), secret(map[string]interface{}{"same": "c2FtZQ==", "key": "bmV3"}))
+	assert.NotContains(t, d, "c2VjcmV0")
+	assert.NotContains(t, d, "bmV3")
+	assert.NotContains(t, d, "c2FtZQ==")
+	assert.Contains(t, d, "*** (before)")
+	assert.Contains(t, d, "*** (after)")
+}
@@ -69,6 +69,7 @@ func visit(path []string, v reflect.Value, fn VisitorFn) {
 // It is a copy of the the function in gopl.io/ch11/format with the following modifications:
 //	- case reflect.Interface is added.
 //	- strings are printed without quotes.
+//	- floats are printed without exponent (JSON numbers decode as float64).
 func formatAtom(v reflect.Value) string {
 	switch v.Kind() {
 	case reflect.Invalid:
@@ -79,7 +80,9 @@ func formatAtom(v reflect.Value) string {
 	case reflect.Uint, reflect.Uint8, reflect.Uint16,
 		reflect.Uint32, reflect.Uint64, reflect.Uintptr:
 		return strconv.FormatUint(v.Uint(), 10)
-	// ...floating-point and complex cases omitted for brevity...
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64)
+	// ...complex cases omitted for brevity...
 	case reflect.Bool:
 		if v.Bool() {
 			return "true"
@@ -1,9 +1,11 @@
 package cluster
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/yaml.v2"
+	"sort"
 	"strings"
 	"testing"
 )
@@ -57,6 +59,17 @@ elem1:
 	// [PREFIX_VALUE1=v_one PREFIX_ELEM1_0=e_one PREFIX_ELEM1_1=e_two]
 }
 
+func Example_json_to_environment_variables() {
+	s := `{"a": {"b": ["x"]}, "replicas": 3, "ratio": 0.5}`
+	var v map[string]interface{}
+	_ = json.Unmarshal([]byte(s), &v)
+	env := MapToEnv(v, "VALUE_")
+	sort.Strings(env)
+	fmt.Println(env)
+	// Output:
+	// [VALUE_A_B_0=x VALUE_RATIO=0.5 VALUE_REPLICAS=3]
+}
+
 // Example_visitfn_env is an example of a VisitFn to create environment variables.
 func Example_visit_function() {
 	var result string
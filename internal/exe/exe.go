@@ -2,9 +2,12 @@ package exe
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"github.com/go-logr/logr"
 	"os/exec"
+	"regexp"
+	"syscall"
 )
 
 // Args are the command arguments.
@@ -21,19 +24,87 @@ type Opt struct {
 
 // Action executes 'cmd' with 'args' and 'options'.
 // Upon completion it returns stdout and stderr.
-func Run(cmd string, args Args, options Opt, log logr.Logger) (string, string, error) {
-	log.V(2).Info("Action", "cmd", cmd, "args", args)
+// When ctx is done before completion the command and its child processes are killed.
+func Run(ctx context.Context, cmd string, args Args, options Opt, log logr.Logger) (string, string, error) {
+	log.V(2).Info("Action", "cmd", cmd, "args", args.Redacted())
 	c := exec.Command(cmd, args...)
 	c.Env = options.Env
 	c.Dir = options.Dir
 	var stdout, stderr bytes.Buffer
 	c.Stdout, c.Stderr = &stdout, &stderr
-	err := c.Run()
+	err := run(ctx, c)
 	outStr, errStr := string(stdout.Bytes()), string(stderr.Bytes())
 	log.V(3).Info("Action-result", "stderr", errStr, "stdout", outStr)
 	if err != nil {
-		return "", "", fmt.Errorf("%s %v: %w - %s", cmd, args, err, errStr)
+		return "", "", fmt.Errorf("%s %v: %w - %s", cmd, args.Redacted(), err, redact(errStr))
 	}
 
 	return outStr, errStr, nil
 }
+
+// RunCombined executes 'cmd' with 'args' and 'options'.
+// Upon completion it returns stdout and stderr combined in the order they have been written.
+// The output is also returned when the command fails.
+// When ctx is done before completion the command and its child processes are killed.
+func RunCombined(ctx context.Context, cmd string, args Args, options Opt, log logr.Logger) (string, error) {
+	log.V(2).Info("Action", "cmd", cmd, "args", args.Redacted())
+	c := exec.Command(cmd, args...)
+	c.Env = options.Env
+	c.Dir = options.Dir
+	var out bytes.Buffer
+	c.Stdout, c.Stderr = &out, &out
+	err := run(ctx, c)
+	outStr := string(out.Bytes())
+	log.V(3).Info("Action-result", "output", outStr)
+	if err != nil {
+		return outStr, fmt.Errorf("%s %v: %w", cmd, args.Redacted(), err)
+	}
+
+	return outStr, nil
+}
+
+// Credentials matches the userinfo of an URL and the value of an Authorization header.
+var credentials = regexp.MustCompile(`(://)[^/@\s]+(@)|((?i:authorization):\s*(?:\S+\s+)?)\S+`)
+
+// Redacted returns a copy of args with credentials (like the token in 'https://token@host/repo') replaced by '***'
+// so args can be logged and returned in errors.
+func (args Args) Redacted() Args {
+	r := make(Args, len(args))
+	for i, a := range args {
+		r[i] = redact(a)
+	}
+	return r
+}
+
+// Redact replaces credentials in s by '***'.
+func redact(s string) string {
+	return credentials.ReplaceAllString(s, "${1}${3}***${2}")
+}
+
+// Run starts c in its own process group and waits for it to complete.
+// When ctx is done the process group is killed so commands started by c (like kubectl in a script) are killed too.
+// The ctx error is returned when c has been killed.
+func run(ctx context.Context, c *exec.Cmd) error {
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	err := c.Start()
+	if err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = syscall.Kill(-c.Process.Pid, syscall.SIGKILL)
+		case <-done:
+		}
+	}()
+
+	err = c.Wait()
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return err
+}
@@ -0,0 +1,114 @@
+// Install Helm charts.
+package helm
+
+// Package helm provides a simple wrapper around the helm (v3) cli.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/ghodss/yaml"
+	"github.com/go-logr/logr"
+	"github.com/mmlt/operator-addons/internal/exe"
+)
+
+// Helm runs helm against a target cluster.
+type Helm struct {
+	// Home is the directory with the .kube/config of the target cluster.
+	// It is also used for helm cache and config files.
+	Home string
+
+	// Log is the helm specific logger.
+	Log logr.Logger
+}
+
+// Release is the status of a Helm release.
+type Release struct {
+	Name      string
+	Namespace string
+	// Revision is the number of the last release revision.
+	Revision int
+	// Status of the last revision, for example deployed, failed, pending-upgrade.
+	Status string
+}
+
+// Upgrade installs or upgrades release in namespace with the chart at path and values.
+// It returns the output of helm, also when the upgrade fails.
+func (h *Helm) Upgrade(ctx context.Context, release, namespace, chart string, values interface{}) (string, error) {
+	d, err := yaml.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	p := filepath.Join(h.Home, "helm-values.yaml")
+	err = ioutil.WriteFile(p, d, 0600)
+	if err != nil {
+		return "", err
+	}
+
+	return exe.RunCombined(ctx, "helm", exe.Args{"upgrade", release, chart, "--install", "--create-namespace", "--wait",
+		"--namespace", namespace, "--values", p}, h.opt(), h.Log)
+}
+
+// Rollback rolls release back to the previous revision.
+func (h *Helm) Rollback(ctx context.Context, release, namespace string) (string, error) {
+	return exe.RunCombined(ctx, "helm", exe.Args{"rollback", release, "--wait", "--namespace", namespace}, h.opt(), h.Log)
+}
+
+// Uninstall removes release and the objects it has installed.
+func (h *Helm) Uninstall(ctx context.Context, release, namespace string) (string, error) {
+	return exe.RunCombined(ctx, "helm", exe.Args{"uninstall", release, "--namespace", namespace}, h.opt(), h.Log)
+}
+
+// Status returns the status of release or nil when the release doesn't exist.
+func (h *Helm) Status(ctx context.Context, release, namespace string) (*Release, error) {
+	o, _, err := exe.Run(ctx, "helm", exe.Args{"list", "--all", "--namespace", namespace,
+		"--filter", "^" + release + "$", "--output", "json"}, h.opt(), h.Log)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseList(o, release)
+}
+
+// ParseList returns release from the output of 'helm list -o json' or nil when it's not listed.
+func parseList(s, release string) (*Release, error) {
+	var list []struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+		Revision  string `json:"revision"`
+		Status    string `json:"status"`
+	}
+	err := json.Unmarshal([]byte(s), &list)
+	if err != nil {
+		return nil, fmt.Errorf("helm list: %w", err)
+	}
+
+	for _, r := range list {
+		if r.Name != release {
+			continue
+		}
+		rev, err := strconv.Atoi(r.Revision)
+		if err != nil {
+			return nil, fmt.Errorf("helm list: revision: %w", err)
+		}
+		return &Release{Name: r.Name, Namespace: r.Namespace, Revision: rev, Status: r.Status}, nil
+	}
+
+	return nil, nil
+}
+
+// Opt returns the options to run helm against the target cluster.
+func (h *Helm) opt() exe.Opt {
+	return exe.Opt{
+		Dir: h.Home,
+		Env: append(os.Environ(),
+			"HOME="+h.Home,
+			"KUBECONFIG="+filepath.Join(h.Home, ".kube", "config"),
+		),
+	}
+}
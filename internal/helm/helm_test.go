@@ -0,0 +1,23 @@
+package helm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseList(t *testing.T) {
+	s := `[{"name":"ingress","namespace":"infra","revision":"3","updated":"2020-01-02 03:04:05.678 +0000 UTC","status":"deployed","chart":"nginx-ingress-1.0.0","app_version":"0.26.1"}]`
+
+	r, err := parseList(s, "ingress")
+	require.NoError(t, err)
+	assert.Equal(t, &Release{Name: "ingress", Namespace: "infra", Revision: 3, Status: "deployed"}, r)
+
+	r, err = parseList(s, "other")
+	require.NoError(t, err)
+	assert.Nil(t, r)
+
+	_, err = parseList("Error", "ingress")
+	assert.Error(t, err)
+}
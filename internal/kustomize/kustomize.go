@@ -0,0 +1,168 @@
+// Build kustomizations in-process.
+package kustomize
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mmlt/operator-addons/internal/cluster"
+	"github.com/mmlt/operator-addons/internal/manifest"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/kustomize/api/filesys"
+	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/api/resid"
+	"sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/yaml"
+)
+
+// ValuesConfigMap is the ConfigMap with values that is added to a kustomization.
+type ValuesConfigMap struct {
+	Name      string
+	Namespace string
+	// SecretKeys are the top-level values that come from Secrets.
+	// They're added to a Secret with the same name and namespace as the ConfigMap instead.
+	SecretKeys map[string]bool
+}
+
+// Build builds the kustomization in dir and returns the resulting objects.
+//
+// When values are provided a ConfigMap with the values is added to the kustomization.
+// The ConfigMap data keys are the flattened and uppercased paths to the values, for example {a: {b: [x]}} results in
+// key A_B_0 with value x. For each key a var VALUE_<key> is defined that can be used as $(VALUE_A_B_0) in the fields
+// that kustomize substitutes vars in.
+// The values that come from Secrets are added to a Secret (stringData) instead of the ConfigMap.
+func Build(dir string, values map[string]interface{}, cm ValuesConfigMap) ([]*unstructured.Unstructured, error) {
+	if len(values) > 0 {
+		// Wrap the kustomization in a kustomization that adds the values.
+		wd, err := ioutil.TempDir("", "kustomize")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(wd)
+
+		// Kustomize only accepts relative paths to other kustomizations.
+		rel, err := filepath.Rel(wd, dir)
+		if err != nil {
+			return nil, err
+		}
+		k, secret := wrapper(rel, values, cm)
+		if secret != nil {
+			b, err := yaml.Marshal(secret)
+			if err != nil {
+				return nil, err
+			}
+			err = ioutil.WriteFile(filepath.Join(wd, secretFile), b, 0600)
+			if err != nil {
+				return nil, err
+			}
+		}
+		b, err := yaml.Marshal(k)
+		if err != nil {
+			return nil, err
+		}
+		err = ioutil.WriteFile(filepath.Join(wd, "kustomization.yaml"), b, 0644)
+		if err != nil {
+			return nil, err
+		}
+		dir = wd
+	}
+
+	k := krusty.MakeKustomizer(filesys.MakeFsOnDisk(), krusty.MakeDefaultOptions())
+	rm, err := k.Run(dir)
+	if err != nil {
+		return nil, fmt.Errorf("kustomize: %w", err)
+	}
+	b, err := rm.AsYaml()
+	if err != nil {
+		return nil, fmt.Errorf("kustomize: %w", err)
+	}
+
+	return manifest.Decode(b)
+}
+
+// SecretFile is the name of the file with the values Secret in the wrapper kustomization.
+const secretFile = "values-secret.yaml"
+
+// Wrapper returns a kustomization with dir as resource and a ConfigMap and vars with values.
+// The values that come from Secrets are returned as Secret object that the kustomization expects in secretFile.
+func wrapper(dir string, values map[string]interface{}, cm ValuesConfigMap) (*types.Kustomization, map[string]interface{}) {
+	plain := map[string]interface{}{}
+	secret := map[string]interface{}{}
+	for k, v := range values {
+		if cm.SecretKeys[k] {
+			secret[k] = v
+		} else {
+			plain[k] = v
+		}
+	}
+
+	k := &types.Kustomization{
+		TypeMeta: types.TypeMeta{
+			APIVersion: types.KustomizationVersion,
+			Kind:       types.KustomizationKind,
+		},
+		Resources:        []string{dir},
+		GeneratorOptions: &types.GeneratorOptions{DisableNameSuffixHash: true},
+	}
+
+	kvs := cluster.MapToEnv(plain, "")
+	sort.Strings(kvs)
+	if len(kvs) > 0 {
+		k.ConfigMapGenerator = []types.ConfigMapArgs{{
+			GeneratorArgs: types.GeneratorArgs{
+				Namespace:     cm.Namespace,
+				Name:          cm.Name,
+				KvPairSources: types.KvPairSources{LiteralSources: kvs},
+			},
+		}}
+	}
+	k.Vars = append(k.Vars, vars(kvs, "ConfigMap", "data.", cm)...)
+
+	var obj map[string]interface{}
+	skvs := cluster.MapToEnv(secret, "")
+	sort.Strings(skvs)
+	if len(skvs) > 0 {
+		data := map[string]interface{}{}
+		for _, kv := range skvs {
+			ss := strings.SplitN(kv, "=", 2)
+			data[ss[0]] = ss[1]
+		}
+		metadata := map[string]interface{}{"name": cm.Name}
+		if cm.Namespace != "" {
+			metadata["namespace"] = cm.Namespace
+		}
+		obj = map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Secret",
+			"metadata":   metadata,
+			"type":       "Opaque",
+			"stringData": data,
+		}
+		k.Resources = append(k.Resources, secretFile)
+	}
+	k.Vars = append(k.Vars, vars(skvs, "Secret", "stringData.", cm)...)
+
+	return k, obj
+}
+
+// Vars returns a var VALUE_<key> for each key=value in kvs that refers to the field of object kind.
+func vars(kvs []string, kind, field string, cm ValuesConfigMap) []types.Var {
+	var r []types.Var
+	for _, kv := range kvs {
+		key := strings.SplitN(kv, "=", 2)[0]
+		r = append(r, types.Var{
+			Name: "VALUE_" + key,
+			ObjRef: types.Target{
+				Gvk:       resid.Gvk{Version: "v1", Kind: kind},
+				Name:      cm.Name,
+				Namespace: cm.Namespace,
+			},
+			FieldRef: types.FieldSelector{FieldPath: field + key},
+		})
+	}
+	return r
+}
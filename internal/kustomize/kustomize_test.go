@@ -0,0 +1,98 @@
+package kustomize
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuild(t *testing.T) {
+	dir, err := ioutil.TempDir("", "overlay")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"kustomization.yaml": `
+namePrefix: x-
+resources:
+- pod.yaml
+`,
+		"pod.yaml": `
+apiVersion: v1
+kind: Pod
+metadata:
+  name: app
+spec:
+  containers:
+  - name: app
+    image: app
+    args: ["--domain=$(VALUE_INGRESS_DOMAIN)"]
+`,
+	}
+	for n, s := range files {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, n), []byte(s), 0644))
+	}
+
+	t.Run("without_values", func(t *testing.T) {
+		objs, err := Build(dir, nil, ValuesConfigMap{})
+		require.NoError(t, err)
+		require.Len(t, objs, 1)
+		assert.Equal(t, "x-app", objs[0].GetName())
+	})
+
+	t.Run("with_values", func(t *testing.T) {
+		values := map[string]interface{}{"ingress": map[string]interface{}{"domain": "example.com"}}
+		objs, err := Build(dir, values, ValuesConfigMap{Name: "values", Namespace: "infra"})
+		require.NoError(t, err)
+		require.Len(t, objs, 2)
+
+		var cm, pod map[string]interface{}
+		for _, o := range objs {
+			switch o.GetKind() {
+			case "ConfigMap":
+				cm = o.Object
+				assert.Equal(t, "values", o.GetName())
+				assert.Equal(t, "infra", o.GetNamespace())
+			case "Pod":
+				pod = o.Object
+			}
+		}
+		require.NotNil(t, cm)
+		require.NotNil(t, pod)
+		assert.Equal(t, map[string]interface{}{"INGRESS_DOMAIN": "example.com"}, cm["data"])
+		args := pod["spec"].(map[string]interface{})["containers"].([]interface{})[0].(map[string]interface{})["args"]
+		assert.Equal(t, []interface{}{"--domain=example.com"}, args)
+	})
+
+	t.Run("with_secret_values", func(t *testing.T) {
+		values := map[string]interface{}{"ingress": map[string]interface{}{"domain": "example.com"}, "password": "secret"}
+		objs, err := Build(dir, values, ValuesConfigMap{Name: "values", Namespace: "infra", SecretKeys: map[string]bool{"password": true}})
+		require.NoError(t, err)
+		require.Len(t, objs, 3)
+
+		var cm, secret map[string]interface{}
+		for _, o := range objs {
+			switch o.GetKind() {
+			case "ConfigMap":
+				cm = o.Object
+			case "Secret":
+				secret = o.Object
+				assert.Equal(t, "values", o.GetName())
+				assert.Equal(t, "infra", o.GetNamespace())
+			}
+		}
+		require.NotNil(t, cm)
+		require.NotNil(t, secret)
+		assert.Equal(t, map[string]interface{}{"INGRESS_DOMAIN": "example.com"}, cm["data"], "expected no secret values in ConfigMap")
+		assert.Equal(t, map[string]interface{}{"PASSWORD": "secret"}, secret["stringData"])
+	})
+
+	t.Run("error", func(t *testing.T) {
+		_, err := Build(filepath.Join(dir, "missing"), nil, ValuesConfigMap{})
+		assert.Error(t, err)
+	})
+}
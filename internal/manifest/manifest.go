@@ -0,0 +1,201 @@
+// Read Kubernetes objects from YAML files.
+package manifest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/Masterminds/sprig/v3"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// Read returns the objects in the YAML file or the YAML files in the directory at path.
+// Path is relative to root and must not refer to a file outside root.
+// Files are read in lexical order, subdirectories are ignored.
+// When render is true the files are rendered as Go template with the values as .Values
+func Read(root, path string, render bool, values map[string]interface{}) ([]*unstructured.Unstructured, error) {
+	p, err := Join(root, path)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := yamlFiles(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var objs []*unstructured.Unstructured
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		if render {
+			b, err = Render(filepath.Base(f), b, map[string]interface{}{"Values": values})
+			if err != nil {
+				return nil, err
+			}
+		}
+		o, err := Decode(b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f, err)
+		}
+		objs = append(objs, o...)
+	}
+
+	return objs, nil
+}
+
+// Join joins root and path and returns an error when the result is outside root.
+func Join(root, path string) (string, error) {
+	p := filepath.Join(root, path)
+	if p != root && !strings.HasPrefix(p, root+string(filepath.Separator)) {
+		return "", fmt.Errorf("path %s is outside the repository", path)
+	}
+	return p, nil
+}
+
+// YamlFiles returns path when it's a file or the YAML files in path when it's a directory.
+func yamlFiles(path string) ([]string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return []string{path}, nil
+	}
+
+	fis, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var r []string
+	for _, fi := range fis {
+		if !fi.IsDir() && isYaml(fi.Name()) {
+			r = append(r, filepath.Join(path, fi.Name()))
+		}
+	}
+	sort.Strings(r)
+
+	return r, nil
+}
+
+// IsYaml returns true when the file name has a YAML or JSON extension.
+func isYaml(name string) bool {
+	switch filepath.Ext(name) {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
+
+// RenderError is returned when a template can't be rendered.
+type RenderError struct {
+	Name string
+	Err  error
+}
+
+func (e *RenderError) Error() string {
+	return fmt.Sprintf("render %s: %v", e.Name, e.Err)
+}
+
+func (e *RenderError) Unwrap() error {
+	return e.Err
+}
+
+// Render executes the Go template in text with data.
+// The sprig functions (https://masterminds.github.io/sprig/) are available in the template.
+// Referring to a missing value is an error.
+func Render(name string, text []byte, data interface{}) ([]byte, error) {
+	t, err := template.New(name).Funcs(sprig.TxtFuncMap()).Option("missingkey=error").Parse(string(text))
+	if err != nil {
+		return nil, &RenderError{Name: name, Err: err}
+	}
+
+	var b bytes.Buffer
+	err = t.Execute(&b, data)
+	if err != nil {
+		return nil, &RenderError{Name: name, Err: err}
+	}
+
+	return b.Bytes(), nil
+}
+
+// RenderAll renders the YAML files in the directory tree at path as Go template with data and returns the objects.
+// Path is relative to root and must not refer to a file outside root.
+// Files are read in lexical order, hidden files and directories are skipped.
+// Referring to a missing value is an error, use the sprig 'hasKey' or 'get' functions for optional values.
+func RenderAll(root, path string, data interface{}) ([]*unstructured.Unstructured, error) {
+	p, err := Join(root, path)
+	if err != nil {
+		return nil, err
+	}
+
+	var objs []*unstructured.Unstructured
+	err = filepath.Walk(p, func(f string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(fi.Name(), ".") && f != p {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if fi.IsDir() || !isYaml(f) {
+			return nil
+		}
+
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		name, _ := filepath.Rel(root, f)
+		b, err = Render(name, b, data)
+		if err != nil {
+			return err
+		}
+		o, err := Decode(b)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		objs = append(objs, o...)
+		return nil
+	})
+
+	return objs, err
+}
+
+// Decode returns the objects in a (multi document) YAML or JSON text.
+// Empty documents are skipped.
+func Decode(text []byte) ([]*unstructured.Unstructured, error) {
+	var objs []*unstructured.Unstructured
+	d := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(text), 4096)
+	for {
+		obj := &unstructured.Unstructured{}
+		err := d.Decode(&obj.Object)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(obj.Object) == 0 {
+			continue
+		}
+		if obj.GetKind() == "" || obj.GetAPIVersion() == "" {
+			return nil, fmt.Errorf("object without kind or apiVersion: %v", obj.Object)
+		}
+		objs = append(objs, obj)
+	}
+
+	return objs, nil
+}
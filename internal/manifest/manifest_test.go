@@ -0,0 +1,131 @@
+package manifest
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRead(t *testing.T) {
+	root, err := ioutil.TempDir("", "manifest")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	files := map[string]string{
+		"dir/b.yaml": `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: b
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: c
+`,
+		"dir/a.yaml": `
+---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: {{ .Values.namespace }}
+`,
+		"dir/README.md": `not yaml`,
+		"rule.yaml": `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: rule
+data:
+  summary: "{{ $labels.instance }} is down"
+`,
+	}
+	for n, s := range files {
+		p := filepath.Join(root, n)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, ioutil.WriteFile(p, []byte(s), 0644))
+	}
+
+	objs, err := Read(root, "dir", true, map[string]interface{}{"namespace": "x"})
+	require.NoError(t, err)
+	var names []string
+	for _, o := range objs {
+		names = append(names, o.GetKind()+"/"+o.GetName())
+	}
+	assert.Equal(t, []string{"Namespace/x", "ConfigMap/b", "ConfigMap/c"}, names)
+
+	_, err = Read(root, "dir", true, map[string]interface{}{"other": "x"})
+	assert.Error(t, err, "missing value")
+
+	objs, err = Read(root, "rule.yaml", false, map[string]interface{}{"namespace": "x"})
+	require.NoError(t, err, "without render values are ignored")
+	require.Len(t, objs, 1)
+	assert.Equal(t, map[string]interface{}{"summary": "{{ $labels.instance }} is down"}, objs[0].Object["data"])
+
+	_, err = Read(root, "../", false, nil)
+	assert.Error(t, err, "outside root")
+}
+
+func TestDecode(t *testing.T) {
+	_, err := Decode([]byte(`metadata: {name: x}`))
+	assert.Error(t, err, "kind expected")
+
+	objs, err := Decode([]byte(`{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "x"}}`))
+	require.NoError(t, err)
+	assert.Len(t, objs, 1)
+}
+
+func TestRenderAll(t *testing.T) {
+	root, err := ioutil.TempDir("", "manifest")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	files := map[string]string{
+		"dir/a.yaml": `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: {{ .Target.Name | lower }}
+data:
+  domain: {{ .Values.domain | quote }}
+`,
+		"dir/sub/b.yaml": `
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: {{ get .Values "namespace" | default "b" }}
+`,
+		"dir/.git/c.yaml": `{{ invalid`,
+		"dir/README.md":   `{{ invalid`,
+	}
+	for n, s := range files {
+		p := filepath.Join(root, n)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, ioutil.WriteFile(p, []byte(s), 0644))
+	}
+
+	data := map[string]interface{}{
+		"Values": map[string]interface{}{"domain": "example.com"},
+		"Target": map[string]interface{}{"Name": "Test"},
+	}
+	objs, err := RenderAll(root, "dir", data)
+	require.NoError(t, err)
+	require.Len(t, objs, 2)
+	assert.Equal(t, "test", objs[0].GetName())
+	assert.Equal(t, "b", objs[1].GetName())
+
+	_, err = RenderAll(root, "dir", map[string]interface{}{})
+	var re *RenderError
+	assert.True(t, errors.As(err, &re), "RenderError expected, got %v", err)
+
+	// missing value
+	data["Values"] = map[string]interface{}{}
+	_, err = RenderAll(root, "dir", data)
+	assert.True(t, errors.As(err, &re), "RenderError expected, got %v", err)
+	assert.Equal(t, filepath.Join("dir", "a.yaml"), re.Name)
+}
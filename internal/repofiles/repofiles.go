@@ -0,0 +1,182 @@
+// Get content from files that are kept in the cluster.
+package repofiles
+
+// Package repofiles writes files (for example the content of a ConfigMap) to a local directory so they can be used
+// like the content of a repository.
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-logr/logr"
+)
+
+// Repo represents a set of files.
+type Repo struct {
+	// name of repo.
+	name string
+	// files by path relative to Dir.
+	files map[string][]byte
+	// tempDir is the file path were the files are written.
+	tempDir string
+
+	// Log is the repo specific logger.
+	log logr.Logger
+}
+
+// New creates an environment to write files to.
+// Name identifies the set of files, for example namespace/clusteraddon/source.
+func New(name string, log logr.Logger) (*Repo, error) {
+	r := Repo{
+		name: path.Base(name),
+		log:  log.WithName("Repo").WithValues("repo", path.Base(name)),
+	}
+
+	// Create directory to write the files into.
+	p := filepath.Join(os.TempDir(), "files", Hashed(name))
+	err := os.MkdirAll(p, 0755)
+	if err != nil {
+		return nil, err
+	}
+	r.tempDir = p
+	r.log.V(2).Info("Create dir", "path", p)
+
+	return &r, nil
+}
+
+// SetToken is a no-op; there is no remote server to authenticate with.
+func (r *Repo) SetToken(token string) error {
+	return nil
+}
+
+// SetFiles sets the files (path -> content) that are written by the next Update.
+func (r *Repo) SetFiles(files map[string][]byte) error {
+	for p := range files {
+		if !valid(p) {
+			return fmt.Errorf("file path %s must be relative and stay within the source", p)
+		}
+	}
+	r.files = files
+
+	return nil
+}
+
+// SHAlocal returns the digest of the files that have been written.
+func (r *Repo) SHAlocal() (string, error) {
+	b, err := ioutil.ReadFile(r.digestPath())
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(b)), nil
+}
+
+// Update writes the files when they differ from the files that have been written before.
+func (r *Repo) Update(_ context.Context) error {
+	digest := Digest(r.files)
+	if sha, err := r.SHAlocal(); err == nil && sha == digest {
+		// Already up-to-date
+		return nil
+	}
+
+	// Write in a new directory and replace the current content when done.
+	tmp := r.Dir() + ".tmp"
+	err := os.RemoveAll(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	for p, b := range r.files {
+		f := filepath.Join(tmp, filepath.FromSlash(p))
+		err = os.MkdirAll(filepath.Dir(f), 0755)
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(f, b, 0644)
+		if err != nil {
+			return err
+		}
+	}
+	err = os.MkdirAll(tmp, 0755)
+	if err != nil {
+		return err
+	}
+
+	err = os.RemoveAll(r.Dir())
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp, r.Dir())
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(r.digestPath(), []byte(digest), 0644)
+	if err != nil {
+		return err
+	}
+	r.log.V(1).Info("Write files", "digest", digest)
+
+	return nil
+}
+
+// Version returns an empty string; files have no version other than their digest.
+func (r *Repo) Version() string {
+	return ""
+}
+
+// Remove removes the temporary directory that contains the files.
+func (r *Repo) Remove() error {
+	r.log.V(2).Info("Remove dir", "path", r.tempDir)
+	return os.RemoveAll(r.tempDir)
+}
+
+// Dir returns the absolute path to the files.
+// The files are kept in a subdirectory so the name of the repo can't collide with the digest file.
+func (r *Repo) Dir() string {
+	return filepath.Join(r.tempDir, "content", r.name)
+}
+
+// DigestPath returns the path of the file that contains the digest of the written files.
+func (r *Repo) digestPath() string {
+	return filepath.Join(r.tempDir, "digest")
+}
+
+// Digest returns the sha256 of files (paths and content).
+func Digest(files map[string][]byte) string {
+	paths := make([]string, 0, len(files))
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	h := sha256.New()
+	for _, p := range paths {
+		fmt.Fprintf(h, "%s\x00%d\x00", p, len(files[p]))
+		h.Write(files[p])
+	}
+
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// Valid returns true when p is a relative path that stays within the directory it's relative to.
+func valid(p string) bool {
+	c := path.Clean(p)
+	return p != "" && !path.IsAbs(c) && c != "." && c != ".." && !strings.HasPrefix(c, "../")
+}
+
+// Hashed returns a short version of name that is usable as element of a path.
+func Hashed(name string) string {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+
+	return fmt.Sprintf("%x-%s", h.Sum32(), path.Base(name))
+}
@@ -0,0 +1,70 @@
+package repofiles
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+func TestRepo_Update(t *testing.T) {
+	r, err := New("ns/ca/base", zap.Logger(true))
+	require.NoError(t, err)
+	defer r.Remove()
+
+	// write
+	require.NoError(t, r.SetFiles(map[string][]byte{"policy.yaml": []byte("kind: NetworkPolicy")}))
+	err = r.Update(context.Background())
+	require.NoError(t, err)
+	sha1, err := r.SHAlocal()
+	require.NoError(t, err)
+	b, err := ioutil.ReadFile(filepath.Join(r.Dir(), "policy.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, "kind: NetworkPolicy", string(b))
+
+	// change
+	require.NoError(t, r.SetFiles(map[string][]byte{"rbac/binding.yaml": []byte("kind: RoleBinding")}))
+	err = r.Update(context.Background())
+	require.NoError(t, err)
+	sha2, err := r.SHAlocal()
+	require.NoError(t, err)
+	assert.NotEqual(t, sha1, sha2)
+	assert.FileExists(t, filepath.Join(r.Dir(), "rbac", "binding.yaml"))
+	_, err = ioutil.ReadFile(filepath.Join(r.Dir(), "policy.yaml"))
+	assert.Error(t, err, "expected removed file to be gone")
+}
+
+func TestRepo_Update_named_digest(t *testing.T) {
+	r, err := New("ns/ca/digest", zap.Logger(true))
+	require.NoError(t, err)
+	defer r.Remove()
+
+	require.NoError(t, r.SetFiles(map[string][]byte{"policy.yaml": []byte("kind: NetworkPolicy")}))
+	err = r.Update(context.Background())
+	require.NoError(t, err)
+
+	_, err = r.SHAlocal()
+	assert.NoError(t, err)
+	assert.FileExists(t, filepath.Join(r.Dir(), "policy.yaml"))
+}
+
+func TestRepo_SetFiles_invalid(t *testing.T) {
+	r, err := New("ns/ca/base", zap.Logger(true))
+	require.NoError(t, err)
+	defer r.Remove()
+
+	for _, p := range []string{"../x", "/etc/x", "a/../../x", ""} {
+		assert.Error(t, r.SetFiles(map[string][]byte{p: nil}), p)
+	}
+}
+
+func TestDigest(t *testing.T) {
+	a := Digest(map[string][]byte{"a": []byte("1"), "b": []byte("2")})
+
+	assert.Equal(t, a, Digest(map[string][]byte{"b": []byte("2"), "a": []byte("1")}), "expected order independent")
+	assert.NotEqual(t, a, Digest(map[string][]byte{"a": []byte("12")}))
+}
@@ -3,27 +3,45 @@ package repogit
 
 // Package repogit provides a simple wrapper around git cli.
 // Environment $HOME is expected to have .ssh/ directory to authenticate against remote repo.
+// A token is passed to git via the environment and answered by a GIT_ASKPASS program, it's never part of an URL or
+// argument.
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"github.com/Masterminds/semver/v3"
 	"github.com/go-logr/logr"
 	"github.com/mmlt/operator-addons/internal/exe"
 	"hash/fnv"
+	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
 )
 
 // Repo represents the GIT repository to clone/pull from.
 type Repo struct {
 	// name of repo.
 	name string
-	// url of repo.
+	// url of repo (without token).
 	url string
+	// token to authenticate with remote (optional).
+	token string
 	// branch to use.
 	branch string
+	// tag to use instead of a branch.
+	tag string
+	// constraint selects the highest tag that satisfies it instead of a branch.
+	constraint *semver.Constraints
+	// revision is the commit to use instead of the last commit in the branch.
+	revision string
+	// ref is the branch or version that is checked out, it's part of the FQName.
+	ref string
+	// version is the tag that has been selected by SHAremote.
+	version string
 	// tempDir is the file path were the repository is cloned.
 	tempDir string
 
@@ -31,22 +49,87 @@ type Repo struct {
 	log logr.Logger
 }
 
-// New creates an environment to Get data from a remote GIT repo.
-func New(url, branch, token string, log logr.Logger) (*Repo, error) {
+// New creates an environment to Get data from a branch of a remote GIT repo.
+// Owner identifies the user of the clone (for example namespace/clusteraddon/source), owners don't share a clone.
+func New(owner, url, branch, token string, log logr.Logger) (*Repo, error) {
+	r, err := newRepo(owner, url, branch, token, log)
+	if err != nil {
+		return nil, err
+	}
+	r.branch = branch
+
+	return r, nil
+}
+
+// NewRevision creates an environment to Get a commit from a branch of a remote GIT repo.
+// The revision is a (abbreviated) commit SHA that must be on the branch.
+// The remote isn't polled; once cloned the commit doesn't change.
+func NewRevision(owner, url, branch, revision, token string, log logr.Logger) (*Repo, error) {
+	if branch == "" || revision == "" {
+		return nil, errors.New("branch and revision expected")
+	}
+
+	r, err := newRepo(owner, url, RevisionRef(branch, revision), token, log)
+	if err != nil {
+		return nil, err
+	}
+	r.branch = branch
+	r.revision = strings.ToLower(revision)
+
+	return r, nil
+}
+
+// RevisionRef returns a name for revision in branch that is usable as element of a path.
+func RevisionRef(branch, revision string) string {
+	return branch + "@" + revision
+}
+
+// NewVersion creates an environment to Get data from a tag of a remote GIT repo.
+// Either tag or semverRange is specified, a semverRange like '>=1.4 <2' selects the highest matching tag.
+func NewVersion(owner, url, tag, semverRange, token string, log logr.Logger) (*Repo, error) {
+	if (tag == "") == (semverRange == "") {
+		return nil, errors.New("either a tag or a semver range expected")
+	}
+	var c *semver.Constraints
+	if semverRange != "" {
+		var err error
+		c, err = semver.NewConstraint(semverRange)
+		if err != nil {
+			return nil, fmt.Errorf("semver range %s: %w", semverRange, err)
+		}
+	}
+
+	r, err := newRepo(owner, url, VersionRef(tag, semverRange), token, log)
+	if err != nil {
+		return nil, err
+	}
+	r.tag = tag
+	r.constraint = c
+
+	return r, nil
+}
+
+// NewRepo returns a Repo for ref with a directory to clone into.
+func newRepo(owner, url, ref, token string, log logr.Logger) (*Repo, error) {
 	name := path.Base(url)
 	r := Repo{
-		name: name,
-		// As token is part of the url a change of token results in a new environment.
-		// This way we don't have update and existing environment when a token changes,
-		// Drawback is that a long running Pod accumulates repo clones that won't be used anymore.
-		url:    urlWithToken(url, token),
-		branch: branch,
-		log:    log.WithName("Repo").WithValues("repo", name),
+		name:  name,
+		url:   url,
+		token: token,
+		ref:   ref,
+		log:   log.WithName("Repo").WithValues("repo", name),
+	}
+
+	err := writeAskpass()
+	if err != nil {
+		return nil, err
 	}
 
 	// Create directory to clone the repo into.
-	p := filepath.Join(os.TempDir(), Hashed(url, branch))
-	err := os.MkdirAll(p, 0755)
+	h := fnv.New32a()
+	h.Write([]byte(owner))
+	p := filepath.Join(os.TempDir(), fmt.Sprintf("%s-%x", Hashed(url, ref), h.Sum32()))
+	err = os.MkdirAll(p, 0755)
 	if err != nil {
 		return nil, err
 	}
@@ -56,10 +139,50 @@ func New(url, branch, token string, log logr.Logger) (*Repo, error) {
 	return &r, nil
 }
 
+// VersionRef returns a name for tag or semverRange that is usable as element of a path.
+func VersionRef(tag, semverRange string) string {
+	if tag != "" {
+		return "tag-" + tag
+	}
+	h := fnv.New32a()
+	h.Write([]byte(semverRange))
+	return fmt.Sprintf("semver-%x", h.Sum32())
+}
+
+// SetToken sets the token that is used to authenticate with the remote server.
+// The token is passed with each git command so a changed token is used by the next pull of an existing clone.
+func (r *Repo) SetToken(token string) error {
+	if token == r.token {
+		return nil
+	}
+	r.token = token
+
+	if !r.cloned() {
+		return nil
+	}
+	// Clones made by earlier versions have the token in the remote url.
+	// This is a local command so it isn't cancelled.
+	_, _, err := exe.Run(context.Background(), "git", exe.Args{"remote", "set-url", "origin", r.url}, r.optRepoDir(), r.log)
+	if err != nil {
+		return err
+	}
+	r.log.V(1).Info("Token changed")
+
+	return nil
+}
+
 // SHAremote returns the SHA of the last commit to the remote repo.
-func (r *Repo) SHAremote() (string, error) {
+// When a tag is used it returns the SHA of the commit the tag refers to.
+// When a revision is used it's returned without querying the remote.
+func (r *Repo) SHAremote(ctx context.Context) (string, error) {
+	if r.branch == "" {
+		return r.tagRemote(ctx)
+	}
+	if r.revision != "" {
+		return r.revision, nil
+	}
 	//TODO return cached value if called within 1 minute
-	o, _, err := exe.Run("git", exe.Args{"ls-remote", r.url, "refs/heads/" + r.branch}, exe.Opt{}, r.log)
+	o, _, err := exe.Run(ctx, "git", exe.Args{"ls-remote", r.url, "refs/heads/" + r.branch}, r.opt(""), r.log)
 	if err != nil {
 		return "", err
 	}
@@ -78,7 +201,12 @@ func (r *Repo) SHAremote() (string, error) {
 
 // SHAlocal returns the SHA of the last commit to the local repo.
 func (r *Repo) SHAlocal() (string, error) {
-	o, _, err := exe.Run("git", exe.Args{"rev-parse", "refs/heads/" + r.branch}, r.optRepoDir(), r.log)
+	ref := "refs/heads/" + r.branch
+	if r.branch == "" || r.revision != "" {
+		ref = "HEAD"
+	}
+	// This is a local command so it isn't cancelled.
+	o, _, err := exe.Run(context.Background(), "git", exe.Args{"rev-parse", ref}, r.optRepoDir(), r.log)
 	if err != nil {
 		return "", err
 	}
@@ -86,23 +214,108 @@ func (r *Repo) SHAlocal() (string, error) {
 	return strings.TrimRight(o, "\n\r"), nil
 }
 
+// Version returns the tag that is checked out, it's empty when a branch is used.
+func (r *Repo) Version() string {
+	return r.version
+}
+
+// TagRemote selects the tag to use and returns the SHA of the commit it refers to.
+func (r *Repo) tagRemote(ctx context.Context) (string, error) {
+	args := exe.Args{"ls-remote", "--tags", r.url}
+	if r.tag != "" {
+		args = append(args, "refs/tags/"+r.tag, "refs/tags/"+r.tag+"^{}")
+	}
+	o, _, err := exe.Run(ctx, "git", args, r.opt(""), r.log)
+	if err != nil {
+		return "", err
+	}
+
+	tags := parseTags(o)
+	tag, err := r.selectTag(tags)
+	if err != nil {
+		return "", err
+	}
+	r.version = tag
+
+	return tags[tag], nil
+}
+
+// SelectTag returns the tag or the highest tag that satisfies the constraint.
+func (r *Repo) selectTag(tags map[string]string) (string, error) {
+	if r.tag != "" {
+		if _, ok := tags[r.tag]; !ok {
+			return "", fmt.Errorf("tag %s not found", r.tag)
+		}
+		return r.tag, nil
+	}
+
+	var tag string
+	var max *semver.Version
+	for t := range tags {
+		v, err := semver.NewVersion(t)
+		if err != nil || !r.constraint.Check(v) {
+			continue
+		}
+		if max == nil || v.GreaterThan(max) {
+			tag, max = t, v
+		}
+	}
+	if tag == "" {
+		return "", fmt.Errorf("no tag matches semver range %s", r.constraint)
+	}
+
+	return tag, nil
+}
+
+// ParseTags returns the tags and the SHA of the commits they refer to from the output of 'git ls-remote --tags'.
+func parseTags(o string) map[string]string {
+	// An annotated tag is listed twice, the second (peeled) line has the commit SHA.
+	//  a3a053fb28df45e33db1b634c1a45cb76e3d8bdf	refs/tags/v1.0.0
+	//  1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e	refs/tags/v1.0.0^{}
+	const prefix, peeled = "refs/tags/", "^{}"
+	tags := map[string]string{}
+	for _, l := range strings.Split(o, "\n") {
+		ss := strings.Fields(l)
+		if len(ss) != 2 || !strings.HasPrefix(ss[1], prefix) {
+			continue
+		}
+		t := strings.TrimPrefix(ss[1], prefix)
+		if strings.HasSuffix(t, peeled) {
+			tags[strings.TrimSuffix(t, peeled)] = ss[0]
+			continue
+		}
+		if _, ok := tags[t]; !ok {
+			tags[t] = ss[0]
+		}
+	}
+
+	return tags
+}
+
 // Get (clone or pull) the contents of the remote repo.
-func (r *Repo) Get() error {
-	_, err := os.Stat(filepath.Join(r.tempDir, r.name, ".git"))
-	if os.IsNotExist(err) {
+func (r *Repo) Get(ctx context.Context) error {
+	if r.branch == "" {
+		return r.getTag(ctx)
+	}
+	if r.revision != "" {
+		return r.getRevision(ctx)
+	}
+
+	var err error
+	if !r.cloned() {
 		// repo not cloned yet
-		_, _, err = exe.Run("git", exe.Args{"clone", r.url}, r.optTempDir(), r.log)
+		_, _, err = exe.Run(ctx, "git", exe.Args{"clone", r.url, r.name}, r.optTempDir(), r.log)
 		if err != nil {
 			return err
 		}
 
-		_, _, err = exe.Run("git", exe.Args{"checkout", r.branch}, r.optRepoDir(), r.log)
+		_, _, err = exe.Run(ctx, "git", exe.Args{"checkout", r.branch}, r.optRepoDir(), r.log)
 		if err != nil {
 			return err
 		}
 	} else {
 		// repo already cloned
-		_, _, err = exe.Run("git", exe.Args{"pull", "origin", r.branch}, r.optRepoDir(), r.log)
+		_, _, err = exe.Run(ctx, "git", exe.Args{"pull", "origin", r.branch}, r.optRepoDir(), r.log)
 		if err != nil {
 			return err
 		}
@@ -117,6 +330,73 @@ func (r *Repo) Get() error {
 	return nil
 }
 
+// GetRevision clones or fetches the remote repo and checks out the revision after validating it's on the branch.
+func (r *Repo) getRevision(ctx context.Context) error {
+	var err error
+	if !r.cloned() {
+		_, _, err = exe.Run(ctx, "git", exe.Args{"clone", "--no-checkout", r.url, r.name}, r.optTempDir(), r.log)
+	} else {
+		_, _, err = exe.Run(ctx, "git", exe.Args{"fetch", "origin", r.branch}, r.optRepoDir(), r.log)
+	}
+	if err != nil {
+		return err
+	}
+
+	sha, _, err := exe.Run(ctx, "git", exe.Args{"rev-parse", "--verify", "--quiet", r.revision + "^{commit}"}, r.optRepoDir(), r.log)
+	if err != nil {
+		return fmt.Errorf("commit %s not found", r.revision)
+	}
+	sha = strings.TrimSpace(sha)
+	_, _, err = exe.Run(ctx, "git", exe.Args{"merge-base", "--is-ancestor", sha, "refs/remotes/origin/" + r.branch}, r.optRepoDir(), r.log)
+	if err != nil {
+		return fmt.Errorf("commit %s is not on branch %s", r.revision, r.branch)
+	}
+
+	_, _, err = exe.Run(ctx, "git", exe.Args{"checkout", "--detach", sha}, r.optRepoDir(), r.log)
+	if err != nil {
+		return err
+	}
+	r.log.V(1).Info("Clone/fetch", "branch", r.branch, "commit", sha)
+
+	return nil
+}
+
+// GetTag clones or fetches the remote repo and checks out the selected tag.
+func (r *Repo) getTag(ctx context.Context) error {
+	_, err := r.SHAremote(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !r.cloned() {
+		_, _, err = exe.Run(ctx, "git", exe.Args{"clone", "--no-checkout", r.url, r.name}, r.optTempDir(), r.log)
+	} else {
+		_, _, err = exe.Run(ctx, "git", exe.Args{"fetch", "--tags", "--force", "origin"}, r.optRepoDir(), r.log)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, _, err = exe.Run(ctx, "git", exe.Args{"checkout", "--detach", "refs/tags/" + r.version}, r.optRepoDir(), r.log)
+	if err != nil {
+		return err
+	}
+
+	sha, err := r.SHAlocal()
+	if err != nil {
+		return err
+	}
+	r.log.V(1).Info("Clone/fetch", "tag", r.version, "commit", sha)
+
+	return nil
+}
+
+// Cloned returns true when the remote repo has been cloned.
+func (r *Repo) cloned() bool {
+	_, err := os.Stat(filepath.Join(r.Dir(), ".git"))
+	return err == nil
+}
+
 // Remove removes the temporary directory that contains the cloned repository.
 func (r *Repo) Remove() error {
 	r.log.V(2).Info("Remove dir", "path", r.tempDir)
@@ -124,10 +404,10 @@ func (r *Repo) Remove() error {
 }
 
 // FQName is the fully qualified name of the repo.
-// It includes the repo URL and branch.
+// It includes the repo URL and branch or version.
 // The URL is partially hashed so the result is usable as element of a path.
 func (r *Repo) FQName() string {
-	return Hashed(r.url, r.branch)
+	return Hashed(r.url, r.ref)
 }
 
 // Dir returns the absolute path to the repo root.
@@ -136,8 +416,18 @@ func (r *Repo) Dir() string {
 }
 
 // Update updates the git repo to the latest commit in the branch.
-func (r *Repo) Update() error {
-	same, err := r.sameSHA()
+// A revision is only checked out once.
+func (r *Repo) Update(ctx context.Context) error {
+	if r.revision != "" {
+		sha, err := r.SHAlocal()
+		if err == nil && strings.HasPrefix(sha, r.revision) {
+			// Already up-to-date
+			return nil
+		}
+		return r.Get(ctx)
+	}
+
+	same, err := r.sameSHA(ctx)
 	if err != nil {
 		return err
 	}
@@ -145,11 +435,11 @@ func (r *Repo) Update() error {
 		// Already up-to-date
 		return nil
 	}
-	return r.Get()
+	return r.Get(ctx)
 }
 
 // SameSHA returns true when the SHA's of the local and remote GIT repo are the same.
-func (r *Repo) sameSHA() (bool, error) {
+func (r *Repo) sameSHA(ctx context.Context) (bool, error) {
 	lsha, err := r.SHAlocal()
 	if err != nil {
 		var e *os.PathError
@@ -159,7 +449,7 @@ func (r *Repo) sameSHA() (bool, error) {
 		}
 		return false, err
 	}
-	rsha, err := r.SHAremote()
+	rsha, err := r.SHAremote(ctx)
 	if err != nil {
 		return false, err
 	}
@@ -167,14 +457,59 @@ func (r *Repo) sameSHA() (bool, error) {
 	return lsha == rsha, nil
 }
 
-// URLToken merges an optional token into url that starts with 'https://'
-func urlWithToken(url, token string) string {
-	const prefix = "https://"
-	if !strings.HasPrefix(url, prefix) {
+// AskpassScript is a GIT_ASKPASS program that answers the username and password prompts of git with
+// $GIT_AUTH_USERNAME and $GIT_AUTH_PASSWORD.
+// GIT_ASKPASS works with all git versions, unlike config via the environment (GIT_CONFIG_COUNT needs git 2.31).
+const AskpassScript = `#!/bin/sh
+case "$1" in
+Username*) printf '%s\n' "$GIT_AUTH_USERNAME" ;;
+*) printf '%s\n' "$GIT_AUTH_PASSWORD" ;;
+esac
+`
+
+// AskpassPath is the path of the AskpassScript that is used by the git commands of the operator.
+// It's per user because a file of another user can't be overwritten.
+var askpassPath = filepath.Join(os.TempDir(), fmt.Sprintf("repogit-askpass-%d", os.Getuid()))
+
+var askpassOnce sync.Once
+var askpassErr error
+
+// WriteAskpass writes the AskpassScript to askpassPath once.
+// The script contains no credentials, they're passed via the environment of each git command.
+func writeAskpass() error {
+	askpassOnce.Do(func() {
+		askpassErr = ioutil.WriteFile(askpassPath, []byte(AskpassScript), 0700)
+		if askpassErr == nil {
+			// WriteFile doesn't change the mode of an existing file.
+			askpassErr = os.Chmod(askpassPath, 0700)
+		}
+	})
+	return askpassErr
+}
+
+// AuthEnv returns the environment variables that make git authenticate with token to an url that starts with
+// 'https://'.
+func authEnv(url, token string) []string {
+	if token == "" || !strings.HasPrefix(url, "https://") {
 		// no action needed
-		return url
+		return nil
+	}
+	username, password := Credentials(token)
+	return []string{
+		"GIT_ASKPASS=" + askpassPath,
+		"GIT_AUTH_USERNAME=" + username,
+		"GIT_AUTH_PASSWORD=" + password,
+	}
+}
+
+// Credentials returns the username and password in token.
+// A token is 'username:password' or a username (like a GitHub token) without password.
+func Credentials(token string) (string, string) {
+	i := strings.Index(token, ":")
+	if i < 0 {
+		return token, ""
 	}
-	return prefix + token + "@" + url[len(prefix):]
+	return token[:i], token[i+1:]
 }
 
 // Hashed returns a short version of url/branch in alphanum chars only.
@@ -195,10 +530,22 @@ func Hashed(url, branch string) string {
 
 // OptTempDir returns options to exe commands in the temporary directory.
 func (r *Repo) optTempDir() exe.Opt {
-	return exe.Opt{Dir: r.tempDir}
+	return r.opt(r.tempDir)
 }
 
 // OptRepoDir returns options to exe commands in the repository root directory.
 func (r *Repo) optRepoDir() exe.Opt {
-	return exe.Opt{Dir: r.Dir()}
+	return r.opt(r.Dir())
+}
+
+// Opt returns options to exe commands in dir with the credentials to access the remote repo.
+func (r *Repo) opt(dir string) exe.Opt {
+	env := authEnv(r.url, r.token)
+	if env == nil {
+		return exe.Opt{Dir: dir}
+	}
+	env = append(os.Environ(), env...)
+	env = append(env, "GIT_TERMINAL_PROMPT=0")
+
+	return exe.Opt{Dir: dir, Env: env}
 }
@@ -0,0 +1,224 @@
+package repogit
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+func Test_authEnv(t *testing.T) {
+	tests := []struct {
+		url, token string
+		want       []string
+	}{
+		{url: "https://github.com/mmlt/example", token: "x:abc", want: []string{"GIT_ASKPASS=" + askpassPath, "GIT_AUTH_USERNAME=x", "GIT_AUTH_PASSWORD=abc"}},
+		{url: "https://github.com/mmlt/example", token: "abc", want: []string{"GIT_ASKPASS=" + askpassPath, "GIT_AUTH_USERNAME=abc", "GIT_AUTH_PASSWORD="}},
+		{url: "https://github.com/mmlt/example", token: "", want: nil},
+		{url: "git@github.com:mmlt/example.git", token: "x:abc", want: nil},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, authEnv(tt.url, tt.token))
+	}
+}
+
+func TestRepo_Update_token_not_in_error(t *testing.T) {
+	var auth string
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		auth = req.Header.Get("Authorization")
+		if auth == "" {
+			// git only asks for credentials when the server requires them.
+			w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, "denied", http.StatusForbidden)
+	}))
+	defer ts.Close()
+	os.Setenv("GIT_SSL_NO_VERIFY", "true")
+	defer os.Unsetenv("GIT_SSL_NO_VERIFY")
+
+	r, err := New("ns/ca/base", ts.URL+"/mmlt/example.git", "master", "x:secret", zap.Logger(true))
+	require.NoError(t, err)
+	defer r.Remove()
+
+	err = r.Update(context.Background())
+
+	assert.Error(t, err)
+	assert.NotContains(t, err.Error(), "secret")
+	assert.Equal(t, "Basic eDpzZWNyZXQ=", auth)
+}
+
+func TestRepo_Update(t *testing.T) {
+	remote := testRemote(t)
+	defer os.RemoveAll(remote)
+
+	r, err := New("ns/ca/base", remote, "master", "", zap.Logger(true))
+	require.NoError(t, err)
+	defer r.Remove()
+
+	// clone
+	err = r.Update(context.Background())
+	require.NoError(t, err)
+	sha1, err := r.SHAlocal()
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(r.Dir(), "README.md"))
+
+	// pull
+	testGit(t, remote, "commit", "--allow-empty", "-m", "second")
+	err = r.Update(context.Background())
+	require.NoError(t, err)
+	sha2, err := r.SHAlocal()
+	require.NoError(t, err)
+	assert.NotEqual(t, sha1, sha2)
+}
+
+func TestRepo_SetToken(t *testing.T) {
+	r, err := New("ns/ca/base", "https://example.com/mmlt/example.git", "master", "old", zap.Logger(true))
+	require.NoError(t, err)
+	defer r.Remove()
+
+	// Fake a clone.
+	require.NoError(t, os.MkdirAll(r.Dir(), 0755))
+	testGit(t, r.Dir(), "init")
+	testGit(t, r.Dir(), "remote", "add", "origin", "https://old@example.com/mmlt/example.git")
+
+	err = r.SetToken("new")
+	require.NoError(t, err)
+
+	assert.Equal(t, "https://example.com/mmlt/example.git", testGit(t, r.Dir(), "remote", "get-url", "origin"), "token should not be part of the url")
+	assert.Equal(t, Hashed("https://example.com/mmlt/example.git", "master"), r.FQName(), "token should not be part of the name")
+}
+
+func TestRepo_Update_semverRange(t *testing.T) {
+	remote := testRemote(t)
+	defer os.RemoveAll(remote)
+	testGit(t, remote, "tag", "v1.0.0")
+	testGit(t, remote, "commit", "--allow-empty", "-m", "second")
+	testGit(t, remote, "tag", "-a", "v1.4.0", "-m", "annotated")
+	testGit(t, remote, "commit", "--allow-empty", "-m", "third")
+	testGit(t, remote, "tag", "v2.0.0")
+
+	r, err := NewVersion("ns/ca/base", remote, "", ">=1.0 <2", "", zap.Logger(true))
+	require.NoError(t, err)
+	defer r.Remove()
+
+	// clone
+	err = r.Update(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "v1.4.0", r.Version())
+	sha, err := r.SHAlocal()
+	require.NoError(t, err)
+	assert.Equal(t, testGit(t, remote, "rev-parse", "v1.4.0^{commit}"), sha)
+
+	// fetch
+	testGit(t, remote, "tag", "v1.5.0", "v2.0.0")
+	err = r.Update(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "v1.5.0", r.Version())
+	sha, err = r.SHAlocal()
+	require.NoError(t, err)
+	assert.Equal(t, testGit(t, remote, "rev-parse", "v2.0.0"), sha)
+}
+
+func TestRepo_Update_tag(t *testing.T) {
+	remote := testRemote(t)
+	defer os.RemoveAll(remote)
+	testGit(t, remote, "tag", "release")
+
+	r, err := NewVersion("ns/ca/base", remote, "release", "", "", zap.Logger(true))
+	require.NoError(t, err)
+	defer r.Remove()
+
+	err = r.Update(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "release", r.Version())
+	assert.FileExists(t, filepath.Join(r.Dir(), "README.md"))
+
+	r, err = NewVersion("ns/ca/base", remote, "missing", "", "", zap.Logger(true))
+	require.NoError(t, err)
+	defer r.Remove()
+	assert.Error(t, r.Update(context.Background()))
+}
+
+func TestRepo_Update_revision(t *testing.T) {
+	remote := testRemote(t)
+	defer os.RemoveAll(remote)
+	first := testGit(t, remote, "rev-parse", "HEAD")
+	testGit(t, remote, "commit", "--allow-empty", "-m", "second")
+
+	r, err := NewRevision("ns/ca/base", remote, "master", first[:12], "", zap.Logger(true))
+	require.NoError(t, err)
+	defer r.Remove()
+
+	// clone
+	err = r.Update(context.Background())
+	require.NoError(t, err)
+	sha, err := r.SHAlocal()
+	require.NoError(t, err)
+	assert.Equal(t, first, sha)
+
+	// new commits are ignored
+	testGit(t, remote, "commit", "--allow-empty", "-m", "third")
+	err = r.Update(context.Background())
+	require.NoError(t, err)
+	sha, err = r.SHAlocal()
+	require.NoError(t, err)
+	assert.Equal(t, first, sha)
+}
+
+func TestRepo_Update_revision_not_on_branch(t *testing.T) {
+	remote := testRemote(t)
+	defer os.RemoveAll(remote)
+	testGit(t, remote, "checkout", "-b", "other")
+	testGit(t, remote, "commit", "--allow-empty", "-m", "other")
+	other := testGit(t, remote, "rev-parse", "HEAD")
+	testGit(t, remote, "checkout", "master")
+
+	r, err := NewRevision("ns/ca/base", remote, "master", other, "", zap.Logger(true))
+	require.NoError(t, err)
+	defer r.Remove()
+
+	err = r.Update(context.Background())
+
+	assert.EqualError(t, err, "commit "+other+" is not on branch master")
+}
+
+func Test_parseTags(t *testing.T) {
+	o := "a1\trefs/tags/v1.0.0\nb2\trefs/tags/v1.1.0\nc3\trefs/tags/v1.1.0^{}\nd4\trefs/heads/master\n"
+
+	assert.Equal(t, map[string]string{"v1.0.0": "a1", "v1.1.0": "c3"}, parseTags(o))
+}
+
+// TestRemote creates a git repo with a single commit on branch master and returns its path.
+func testRemote(t *testing.T) string {
+	p, err := ioutil.TempDir("", "repogit-remote")
+	require.NoError(t, err)
+
+	testGit(t, p, "init")
+	testGit(t, p, "checkout", "-b", "master")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(p, "README.md"), []byte("test"), 0644))
+	testGit(t, p, "add", "README.md")
+	testGit(t, p, "commit", "-m", "first")
+
+	return p
+}
+
+// TestGit runs a git command in dir and returns its output.
+func testGit(t *testing.T, dir string, args ...string) string {
+	args = append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)
+	c := exec.Command("git", args...)
+	c.Dir = dir
+	o, err := c.CombinedOutput()
+	require.NoError(t, err, string(o))
+	return strings.TrimSpace(string(o))
+}
@@ -0,0 +1,300 @@
+// Package repohttp gets content from a tarball at an HTTP server.
+// It downloads a (gzipped) tar archive and unpacks it in a local directory.
+// Conditional GETs (ETag and Last-Modified) are used so polling an unchanged archive doesn't download it again.
+package repohttp
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/mmlt/operator-addons/internal/archive"
+)
+
+// Repo represents the archive to download.
+type Repo struct {
+	// name of repo.
+	name string
+	// url of the archive.
+	url string
+	// checksum is the expected sha256 of the archive in hex (optional).
+	checksum string
+	// token is 'username:password' or a bearer token to authenticate with the server (optional).
+	token string
+	// tempDir is the file path were the archive is unpacked.
+	tempDir string
+
+	client *http.Client
+
+	// Log is the repo specific logger.
+	log logr.Logger
+}
+
+// Meta is what is known about the unpacked archive.
+type meta struct {
+	// Digest is the sha256 of the archive.
+	Digest string `json:"digest"`
+	// ETag and LastModified are the validators of the archive returned by the server.
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+// New creates an environment to Get an archive from an HTTP server.
+// When checksum (sha256 in hex) is specified the archive must match it.
+// Owner identifies the user of the archive (for example namespace/clusteraddon/source), owners don't share an archive.
+func New(owner, rawurl, checksum, token string, log logr.Logger) (*Repo, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("url %s: scheme http:// or https:// expected", rawurl)
+	}
+
+	name := path.Base(u.Path)
+	for _, ext := range []string{".gz", ".tgz", ".tar"} {
+		name = strings.TrimSuffix(name, ext)
+	}
+	if name == "" || name == "." || name == "/" {
+		name = "archive"
+	}
+	r := Repo{
+		name:     name,
+		url:      rawurl,
+		checksum: strings.ToLower(strings.TrimPrefix(checksum, "sha256:")),
+		token:    token,
+		client:   &http.Client{Timeout: 5 * time.Minute},
+		log:      log.WithName("Repo").WithValues("repo", name),
+	}
+
+	// Create directory to unpack the archive into.
+	h := fnv.New32a()
+	h.Write([]byte(owner))
+	p := filepath.Join(os.TempDir(), "http", fmt.Sprintf("%s-%x", Hashed(rawurl, checksum), h.Sum32()))
+	err = os.MkdirAll(p, 0755)
+	if err != nil {
+		return nil, err
+	}
+	r.tempDir = p
+	r.log.V(2).Info("Create dir", "path", p)
+
+	return &r, nil
+}
+
+// SetToken sets the token that is used to authenticate with the server.
+func (r *Repo) SetToken(token string) error {
+	if token == r.token {
+		return nil
+	}
+	r.token = token
+	r.log.V(1).Info("Token changed")
+
+	return nil
+}
+
+// SHAlocal returns the digest of the archive that has been unpacked.
+func (r *Repo) SHAlocal() (string, error) {
+	m, err := r.meta()
+	if err != nil {
+		return "", err
+	}
+
+	return m.Digest, nil
+}
+
+// Update downloads and unpacks the archive when it has changed at the server.
+// An archive with a checksum is immutable, it's only downloaded once.
+func (r *Repo) Update(ctx context.Context) error {
+	m, err := r.meta()
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if m != nil && r.checksum != "" && m.Digest == "sha256:"+r.checksum {
+		// Already up-to-date
+		return nil
+	}
+
+	return r.get(ctx, m)
+}
+
+// Get downloads the archive and unpacks it.
+// When previous is specified the download is conditional.
+func (r *Repo) get(ctx context.Context, previous *meta) error {
+	req, err := http.NewRequest(http.MethodGet, r.url, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	if previous != nil {
+		if previous.ETag != "" {
+			req.Header.Set("If-None-Match", previous.ETag)
+		}
+		if previous.LastModified != "" {
+			req.Header.Set("If-Modified-Since", previous.LastModified)
+		}
+	}
+	if r.token != "" {
+		if i := strings.Index(r.token, ":"); i >= 0 {
+			req.SetBasicAuth(r.token[:i], r.token[i+1:])
+		} else {
+			req.Header.Set("Authorization", "Bearer "+r.token)
+		}
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified && previous != nil {
+		// Already up-to-date
+		r.log.V(2).Info("Not modified")
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", r.url, resp.Status)
+	}
+
+	// Download and verify before unpacking.
+	f, err := ioutil.TempFile(r.tempDir, "download")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, h), resp.Body)
+	if err != nil {
+		return fmt.Errorf("GET %s: %w", r.url, err)
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	if r.checksum != "" && sum != r.checksum {
+		return fmt.Errorf("GET %s: sha256 %s doesn't match checksum %s", r.url, sum, r.checksum)
+	}
+	m := &meta{
+		Digest:       "sha256:" + sum,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+
+	if previous == nil || previous.Digest != m.Digest {
+		_, err = f.Seek(0, io.SeekStart)
+		if err != nil {
+			return err
+		}
+		err = r.unpack(f)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = r.writeMeta(m)
+	if err != nil {
+		return err
+	}
+	r.log.V(1).Info("Download", "digest", m.Digest)
+
+	return nil
+}
+
+// Unpack extracts the archive read from f into Dir.
+// The archive is unpacked in a new directory that replaces the current content when done.
+func (r *Repo) unpack(f io.Reader) error {
+	tmp := r.Dir() + ".tmp"
+	err := os.RemoveAll(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	err = os.MkdirAll(tmp, 0755)
+	if err != nil {
+		return err
+	}
+
+	br := bufio.NewReader(f)
+	magic, _ := br.Peek(2)
+	if len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		err = archive.ExtractTarGz(br, tmp)
+	} else {
+		err = archive.ExtractTar(br, tmp)
+	}
+	if err != nil {
+		return fmt.Errorf("unpack %s: %w", r.url, err)
+	}
+
+	err = os.RemoveAll(r.Dir())
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.Dir())
+}
+
+// Version returns an empty string; an archive has no version other than its digest.
+func (r *Repo) Version() string {
+	return ""
+}
+
+// Remove removes the temporary directory that contains the unpacked archive.
+func (r *Repo) Remove() error {
+	r.log.V(2).Info("Remove dir", "path", r.tempDir)
+	return os.RemoveAll(r.tempDir)
+}
+
+// Dir returns the absolute path to the unpacked archive.
+// The archive is kept in a subdirectory so the name of the repo can't collide with the meta data file.
+func (r *Repo) Dir() string {
+	return filepath.Join(r.tempDir, "content", r.name)
+}
+
+// Meta returns what is known about the unpacked archive.
+func (r *Repo) meta() (*meta, error) {
+	b, err := ioutil.ReadFile(r.metaPath())
+	if err != nil {
+		return nil, err
+	}
+	m := &meta{}
+	err = json.Unmarshal(b, m)
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// WriteMeta writes m to disk.
+func (r *Repo) writeMeta(m *meta) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(r.metaPath(), b, 0644)
+}
+
+// MetaPath returns the path of the file that contains the meta data of the unpacked archive.
+func (r *Repo) metaPath() string {
+	return filepath.Join(r.tempDir, "meta.json")
+}
+
+// Hashed returns a short version of url/checksum that is usable as element of a path.
+func Hashed(rawurl, checksum string) string {
+	h := fnv.New32a()
+	h.Write([]byte(rawurl))
+	h.Write([]byte(checksum))
+
+	return fmt.Sprintf("%x", h.Sum32())
+}
@@ -0,0 +1,173 @@
+package repohttp
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+func TestRepo_Update(t *testing.T) {
+	srv := &testServer{}
+	srv.set(testTarGz(t, map[string]string{"addon/a.yaml": "kind: A"}))
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	r, err := New("ns/ca/base", ts.URL+"/addon-1.0.tar.gz", "", "", zap.Logger(true))
+	require.NoError(t, err)
+	defer r.Remove()
+
+	// download
+	err = r.Update(context.Background())
+	require.NoError(t, err)
+	sha1, err := r.SHAlocal()
+	require.NoError(t, err)
+	assert.Equal(t, "sha256:"+srv.sum(), sha1)
+	b, err := ioutil.ReadFile(filepath.Join(r.Dir(), "addon", "a.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, "kind: A", string(b))
+
+	// not modified
+	err = r.Update(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, srv.downloads, "expected a conditional GET")
+
+	// modified
+	srv.set(testTarGz(t, map[string]string{"addon/b.yaml": "kind: B"}))
+	err = r.Update(context.Background())
+	require.NoError(t, err)
+	sha2, err := r.SHAlocal()
+	require.NoError(t, err)
+	assert.NotEqual(t, sha1, sha2)
+	assert.FileExists(t, filepath.Join(r.Dir(), "addon", "b.yaml"))
+}
+
+func TestRepo_Update_named_meta(t *testing.T) {
+	srv := &testServer{}
+	srv.set(testTarGz(t, map[string]string{"a.yaml": "kind: A"}))
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	// The name of the repo is the archive file name.
+	r, err := New("ns/ca/base", ts.URL+"/meta.json", "", "", zap.Logger(true))
+	require.NoError(t, err)
+	defer r.Remove()
+
+	err = r.Update(context.Background())
+	require.NoError(t, err)
+
+	_, err = r.SHAlocal()
+	assert.NoError(t, err)
+	assert.FileExists(t, filepath.Join(r.Dir(), "a.yaml"))
+}
+
+func TestRepo_Update_checksum(t *testing.T) {
+	srv := &testServer{}
+	srv.set(testTarGz(t, map[string]string{"a.yaml": "kind: A"}))
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	r, err := New("ns/ca/base", ts.URL+"/addon.tgz", srv.sum(), "", zap.Logger(true))
+	require.NoError(t, err)
+	defer r.Remove()
+
+	err = r.Update(context.Background())
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(r.Dir(), "a.yaml"))
+
+	// An archive with a checksum is downloaded once.
+	err = r.Update(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, srv.requests)
+
+	// Mismatch.
+	r, err = New("ns/ca/base", ts.URL+"/addon.tgz", "0123", "", zap.Logger(true))
+	require.NoError(t, err)
+	defer r.Remove()
+	err = r.Update(context.Background())
+	assert.Error(t, err)
+	_, err = r.SHAlocal()
+	assert.Error(t, err, "expected nothing unpacked")
+}
+
+func TestRepo_Update_cancelled(t *testing.T) {
+	srv := &testServer{}
+	srv.set(testTarGz(t, map[string]string{"a.yaml": "kind: A"}))
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	r, err := New("ns/ca/base", ts.URL+"/addon.tgz", "", "", zap.Logger(true))
+	require.NoError(t, err)
+	defer r.Remove()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = r.Update(ctx)
+	assert.True(t, errors.Is(err, context.Canceled), "got %v", err)
+	assert.Equal(t, 0, srv.requests)
+}
+
+// TestServer serves a single archive with an ETag.
+type testServer struct {
+	mu        sync.Mutex
+	archive   []byte
+	requests  int
+	downloads int
+}
+
+func (s *testServer) set(b []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.archive = b
+}
+
+func (s *testServer) sum() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sum := sha256.Sum256(s.archive)
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *testServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+
+	sum := sha256.Sum256(s.archive)
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	if req.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	s.downloads++
+	w.Header().Set("ETag", etag)
+	_, _ = w.Write(s.archive)
+}
+
+// TestTarGz returns a gzipped tar archive with files (path -> content).
+func testTarGz(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	for p, s := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: p, Mode: 0644, Size: int64(len(s)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte(s))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
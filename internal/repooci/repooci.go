@@ -0,0 +1,503 @@
+// Package repooci gets content from an OCI registry.
+// It pulls artifacts from an OCI (docker) registry using the distribution API.
+// The layers of an artifact are expected to be (gzipped) tar archives, they are unpacked in a local directory.
+package repooci
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/mmlt/operator-addons/internal/archive"
+)
+
+// Media types of manifests that can be pulled.
+const (
+	mediaTypeOCIManifest    = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeDockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
+)
+
+// Repo represents the artifact to pull from an OCI registry.
+type Repo struct {
+	// name of repo.
+	name string
+	// url of the artifact repository as specified, for example oci://ghcr.io/mmlt/addons.
+	url string
+	// registry is the base URL of the registry API, for example https://ghcr.io.
+	registry string
+	// repository is the path of the artifact in the registry, for example mmlt/addons.
+	repository string
+	// tag of the artifact to pull.
+	tag string
+	// token is 'username:password' to authenticate with the registry (optional).
+	token string
+	// bearer is the token received from the registry authorization service.
+	bearer string
+	// tempDir is the file path were the artifact is unpacked.
+	tempDir string
+
+	client *http.Client
+
+	// Log is the repo specific logger.
+	log logr.Logger
+}
+
+// New creates an environment to Get an artifact from an OCI registry.
+// Url is the artifact repository in the form 'oci://host/path', use 'http://host/path' for a registry without TLS.
+// Owner identifies the user of the artifact (for example namespace/clusteraddon/source), owners don't share an artifact.
+func New(owner, rawurl, tag, token string, log logr.Logger) (*Repo, error) {
+	registry, repository, err := parseURL(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if tag == "" {
+		tag = "latest"
+	}
+
+	name := path.Base(repository)
+	r := Repo{
+		name:       name,
+		url:        rawurl,
+		registry:   registry,
+		repository: repository,
+		tag:        tag,
+		token:      token,
+		client:     &http.Client{Timeout: 5 * time.Minute},
+		log:        log.WithName("Repo").WithValues("repo", name),
+	}
+
+	// Create directory to unpack the artifact into.
+	h := fnv.New32a()
+	h.Write([]byte(owner))
+	p := filepath.Join(os.TempDir(), "oci", fmt.Sprintf("%s-%x", Hashed(rawurl, tag), h.Sum32()))
+	err = os.MkdirAll(p, 0755)
+	if err != nil {
+		return nil, err
+	}
+	r.tempDir = p
+	r.log.V(2).Info("Create dir", "path", p)
+
+	return &r, nil
+}
+
+// SetToken sets the token that is used to authenticate with the registry.
+func (r *Repo) SetToken(token string) error {
+	if token == r.token {
+		return nil
+	}
+	r.token = token
+	r.bearer = ""
+	r.log.V(1).Info("Token changed")
+
+	return nil
+}
+
+// SHAremote returns the digest of the artifact the tag refers to.
+func (r *Repo) SHAremote(ctx context.Context) (string, error) {
+	resp, err := r.do(ctx, http.MethodHead, "manifests/"+r.tag)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	d := resp.Header.Get("Docker-Content-Digest")
+	if d != "" {
+		return d, nil
+	}
+
+	// The registry doesn't tell, calculate the digest of the manifest.
+	_, d, err = r.manifest(ctx, r.tag)
+	return d, err
+}
+
+// SHAlocal returns the digest of the artifact that has been unpacked.
+func (r *Repo) SHAlocal() (string, error) {
+	b, err := ioutil.ReadFile(r.digestPath())
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(b)), nil
+}
+
+// Get pulls the artifact the tag refers to and unpacks it.
+func (r *Repo) Get(ctx context.Context) error {
+	digest, err := r.SHAremote(ctx)
+	if err != nil {
+		return err
+	}
+	return r.pull(ctx, digest)
+}
+
+// Pull pulls the artifact with digest and unpacks it.
+// The artifact is pulled by digest so the content matches the digest the tag referred to, also when the tag has moved
+// in the meantime.
+func (r *Repo) pull(ctx context.Context, digest string) error {
+	m, d, err := r.manifest(ctx, digest)
+	if err != nil {
+		return err
+	}
+	if d != digest {
+		return fmt.Errorf("manifest %s: digest mismatch, got %s", digest, d)
+	}
+
+	// Unpack in a new directory and replace the current content when all layers are done.
+	tmp := r.Dir() + ".tmp"
+	err = os.RemoveAll(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	err = os.MkdirAll(tmp, 0755)
+	if err != nil {
+		return err
+	}
+
+	var n int
+	for _, l := range m.Layers {
+		switch {
+		case strings.HasSuffix(l.MediaType, "+gzip") || strings.HasSuffix(l.MediaType, ".gzip"):
+			err = r.blob(ctx, l.Digest, tmp, archive.ExtractTarGz)
+		case strings.HasSuffix(l.MediaType, ".tar"):
+			err = r.blob(ctx, l.Digest, tmp, archive.ExtractTar)
+		default:
+			r.log.V(2).Info("Skip layer", "mediaType", l.MediaType, "digest", l.Digest)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		n++
+	}
+	if n == 0 {
+		return fmt.Errorf("artifact %s:%s has no tar layers", r.repository, r.tag)
+	}
+
+	err = os.RemoveAll(r.Dir())
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp, r.Dir())
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(r.digestPath(), []byte(digest), 0644)
+	if err != nil {
+		return err
+	}
+	r.log.V(1).Info("Pull", "tag", r.tag, "digest", digest)
+
+	return nil
+}
+
+// Version returns the tag of the artifact.
+func (r *Repo) Version() string {
+	return r.tag
+}
+
+// Remove removes the temporary directory that contains the unpacked artifact.
+func (r *Repo) Remove() error {
+	r.log.V(2).Info("Remove dir", "path", r.tempDir)
+	return os.RemoveAll(r.tempDir)
+}
+
+// Dir returns the absolute path to the unpacked artifact.
+// The artifact is kept in a subdirectory so the name of the repo can't collide with the digest file.
+func (r *Repo) Dir() string {
+	return filepath.Join(r.tempDir, "content", r.name)
+}
+
+// Update updates the unpacked artifact to the digest the tag refers to.
+func (r *Repo) Update(ctx context.Context) error {
+	rsha, err := r.SHAremote(ctx)
+	if err != nil {
+		return err
+	}
+	lsha, err := r.SHAlocal()
+	if err == nil && lsha == rsha {
+		// Already up-to-date
+		return nil
+	}
+	return r.pull(ctx, rsha)
+}
+
+// DigestPath returns the path of the file that contains the digest of the unpacked artifact.
+func (r *Repo) digestPath() string {
+	return filepath.Join(r.tempDir, "digest")
+}
+
+// Manifest is the part of an OCI image manifest that is used.
+type manifest struct {
+	MediaType string `json:"mediaType"`
+	Layers    []struct {
+		MediaType string `json:"mediaType"`
+		Digest    string `json:"digest"`
+	} `json:"layers"`
+}
+
+// Manifest returns the manifest and its digest for reference ref (a tag or digest).
+func (r *Repo) manifest(ctx context.Context, ref string) (*manifest, string, error) {
+	resp, err := r.do(ctx, http.MethodGet, "manifests/"+ref)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	m := &manifest{}
+	err = json.Unmarshal(b, m)
+	if err != nil {
+		return nil, "", fmt.Errorf("manifest %s: %w", ref, err)
+	}
+	t := m.MediaType
+	if t == "" {
+		t = resp.Header.Get("Content-Type")
+	}
+	if t != mediaTypeOCIManifest && t != mediaTypeDockerManifest {
+		return nil, "", fmt.Errorf("manifest %s: media type %s is not supported", ref, t)
+	}
+
+	sum := sha256.Sum256(b)
+	return m, "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// Blob reads the blob with digest, verifies it and passes it to extract to unpack it into dir.
+func (r *Repo) blob(ctx context.Context, digest, dir string, extract func(io.Reader, string) error) error {
+	if !strings.HasPrefix(digest, "sha256:") {
+		return fmt.Errorf("blob %s: digest algorithm not supported", digest)
+	}
+
+	resp, err := r.do(ctx, http.MethodGet, "blobs/"+digest)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	h := sha256.New()
+	err = extract(io.TeeReader(resp.Body, h), dir)
+	if err != nil {
+		return fmt.Errorf("blob %s: %w", digest, err)
+	}
+	// Read the remainder (tar padding) so the digest covers the whole blob.
+	_, err = io.Copy(h, resp.Body)
+	if err != nil {
+		return err
+	}
+	if got := "sha256:" + hex.EncodeToString(h.Sum(nil)); got != digest {
+		return fmt.Errorf("blob %s: digest mismatch, got %s", digest, got)
+	}
+
+	return nil
+}
+
+// Do performs a request to the registry API of the repository and returns a response with status 200.
+// When the registry asks for it an authorization token is requested.
+func (r *Repo) do(ctx context.Context, method, p string) (*http.Response, error) {
+	u := r.registry + "/v2/" + r.repository + "/" + p
+	resp, err := r.request(ctx, method, u)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		// Get a new token, a previous token might have expired.
+		r.bearer = ""
+		err = r.authorize(ctx, challenge)
+		if err != nil {
+			return nil, err
+		}
+		resp, err = r.request(ctx, method, u)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: %s", method, u, resp.Status)
+	}
+
+	return resp, nil
+}
+
+// Request performs a single request with the credentials that are known.
+func (r *Repo) request(ctx context.Context, method, u string) (*http.Response, error) {
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", mediaTypeOCIManifest+", "+mediaTypeDockerManifest)
+	if r.bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+r.bearer)
+	} else if user, password, ok := r.credentials(); ok {
+		req.SetBasicAuth(user, password)
+	}
+
+	return r.client.Do(req)
+}
+
+// Authorize gets a bearer token from the authorization service in challenge.
+// A challenge for basic authentication is answered with the credentials in the token.
+func (r *Repo) authorize(ctx context.Context, challenge string) error {
+	scheme, params := parseChallenge(challenge)
+	user, password, ok := r.credentials()
+	switch scheme {
+	case "basic":
+		if !ok {
+			return fmt.Errorf("registry %s requires credentials", r.registry)
+		}
+		return nil
+	case "bearer":
+	default:
+		return fmt.Errorf("registry %s: unsupported authentication %q", r.registry, challenge)
+	}
+
+	u, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return fmt.Errorf("registry %s: invalid realm in %q", r.registry, challenge)
+	}
+	q := u.Query()
+	if s := params["service"]; s != "" {
+		q.Set("service", s)
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = "repository:" + r.repository + ":pull"
+	}
+	q.Set("scope", scope)
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	if ok {
+		req.SetBasicAuth(user, password)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("authorize %s: %s", u.Host, resp.Status)
+	}
+
+	var tr struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&tr)
+	if err != nil {
+		return fmt.Errorf("authorize %s: %w", u.Host, err)
+	}
+	r.bearer = tr.Token
+	if r.bearer == "" {
+		r.bearer = tr.AccessToken
+	}
+	if r.bearer == "" {
+		return fmt.Errorf("authorize %s: no token received", u.Host)
+	}
+
+	return nil
+}
+
+// Credentials returns the user and password in the token.
+func (r *Repo) credentials() (string, string, bool) {
+	if r.token == "" {
+		return "", "", false
+	}
+	i := strings.Index(r.token, ":")
+	if i < 0 {
+		return "", r.token, true
+	}
+	return r.token[:i], r.token[i+1:], true
+}
+
+// ParseChallenge returns the lower case scheme and parameters of a WWW-Authenticate header value.
+// For example: Bearer realm="https://auth.docker.io/token",service="registry.docker.io"
+func parseChallenge(s string) (string, map[string]string) {
+	params := map[string]string{}
+	i := strings.Index(s, " ")
+	if i < 0 {
+		return strings.ToLower(s), params
+	}
+	scheme := strings.ToLower(s[:i])
+	for _, kv := range splitParams(s[i+1:]) {
+		j := strings.Index(kv, "=")
+		if j < 0 {
+			continue
+		}
+		params[strings.TrimSpace(kv[:j])] = strings.Trim(strings.TrimSpace(kv[j+1:]), `"`)
+	}
+	return scheme, params
+}
+
+// SplitParams splits s at the commas that are not quoted.
+func splitParams(s string) []string {
+	var result []string
+	var quoted bool
+	start := 0
+	for i, c := range s {
+		switch c {
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				result = append(result, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(result, s[start:])
+}
+
+// ParseURL returns the registry API base URL and the repository path of an artifact URL.
+func parseURL(rawurl string) (string, string, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "", "", err
+	}
+	scheme := u.Scheme
+	switch scheme {
+	case "oci":
+		scheme = "https"
+	case "http", "https":
+	default:
+		return "", "", fmt.Errorf("url %s: scheme oci:// expected", rawurl)
+	}
+	repository := strings.Trim(u.Path, "/")
+	if u.Host == "" || repository == "" {
+		return "", "", fmt.Errorf("url %s: oci://host/repository expected", rawurl)
+	}
+
+	return scheme + "://" + u.Host, repository, nil
+}
+
+// Hashed returns a short version of url/tag that is usable as element of a path.
+func Hashed(url, tag string) string {
+	h := fnv.New32a()
+	h.Write([]byte(url))
+
+	return fmt.Sprintf("%x-%s-%s", h.Sum32(), path.Base(url), tag)
+}
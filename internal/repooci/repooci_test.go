@@ -0,0 +1,254 @@
+package repooci
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+func Test_parseURL(t *testing.T) {
+	tests := []struct {
+		url, registry, repository string
+		err                       bool
+	}{
+		{url: "oci://ghcr.io/mmlt/addons", registry: "https://ghcr.io", repository: "mmlt/addons"},
+		{url: "http://127.0.0.1:5000/addons/", registry: "http://127.0.0.1:5000", repository: "addons"},
+		{url: "oci://ghcr.io", err: true},
+		{url: "git@github.com:mmlt/addons.git", err: true},
+	}
+	for _, tt := range tests {
+		registry, repository, err := parseURL(tt.url)
+		if tt.err {
+			assert.Error(t, err, tt.url)
+			continue
+		}
+		assert.NoError(t, err, tt.url)
+		assert.Equal(t, tt.registry, registry)
+		assert.Equal(t, tt.repository, repository)
+	}
+}
+
+func Test_parseChallenge(t *testing.T) {
+	scheme, params := parseChallenge(`Bearer realm="https://auth.example.com/token",service="registry",scope="repository:a/b:pull,push"`)
+
+	assert.Equal(t, "bearer", scheme)
+	assert.Equal(t, map[string]string{
+		"realm":   "https://auth.example.com/token",
+		"service": "registry",
+		"scope":   "repository:a/b:pull,push",
+	}, params)
+}
+
+func TestRepo_Update(t *testing.T) {
+	reg := &testRegistry{t: t, user: "user", password: "secret"}
+	srv := httptest.NewServer(reg)
+	defer srv.Close()
+	reg.realm = srv.URL + "/token"
+	reg.push("addons/base", "v1", map[string]string{"deploy/a.yaml": "kind: A"})
+
+	r, err := New("ns/ca/base", srv.URL+"/addons/base", "v1", "user:secret", zap.Logger(true))
+	require.NoError(t, err)
+	defer r.Remove()
+
+	// pull
+	err = r.Update(context.Background())
+	require.NoError(t, err)
+	sha1, err := r.SHAlocal()
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(sha1, "sha256:"))
+	b, err := ioutil.ReadFile(filepath.Join(r.Dir(), "deploy", "a.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, "kind: A", string(b))
+	assert.Equal(t, []string{sha1}, reg.refs, "expected a pull by digest")
+
+	// unchanged
+	pulls := reg.pulls
+	err = r.Update(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, pulls, reg.pulls, "expected no pull of an unchanged tag")
+
+	// tag moved
+	reg.push("addons/base", "v1", map[string]string{"deploy/b.yaml": "kind: B"})
+	err = r.Update(context.Background())
+	require.NoError(t, err)
+	sha2, err := r.SHAlocal()
+	require.NoError(t, err)
+	assert.NotEqual(t, sha1, sha2)
+	assert.FileExists(t, filepath.Join(r.Dir(), "deploy", "b.yaml"))
+	_, err = ioutil.ReadFile(filepath.Join(r.Dir(), "deploy", "a.yaml"))
+	assert.Error(t, err, "expected content of the previous artifact to be removed")
+}
+
+func TestRepo_Update_named_digest(t *testing.T) {
+	reg := &testRegistry{t: t, user: "user", password: "secret"}
+	srv := httptest.NewServer(reg)
+	defer srv.Close()
+	reg.realm = srv.URL + "/token"
+	reg.push("addons/digest", "v1", map[string]string{"a.yaml": "kind: A"})
+
+	// The name of the repo is the last element of the repository.
+	r, err := New("ns/ca/base", srv.URL+"/addons/digest", "v1", "user:secret", zap.Logger(true))
+	require.NoError(t, err)
+	defer r.Remove()
+
+	err = r.Update(context.Background())
+	require.NoError(t, err)
+
+	_, err = r.SHAlocal()
+	assert.NoError(t, err)
+	assert.FileExists(t, filepath.Join(r.Dir(), "a.yaml"))
+}
+
+func TestRepo_Update_digest_mismatch(t *testing.T) {
+	reg := &testRegistry{t: t, user: "user", password: "secret"}
+	srv := httptest.NewServer(reg)
+	defer srv.Close()
+	reg.realm = srv.URL + "/token"
+	reg.push("addons/base", "v1", map[string]string{"a.yaml": "kind: A"})
+	// The registry serves other content for the digest.
+	d := digestOf(reg.manifests["addons/base:v1"])
+	reg.manifests["addons/base:"+d] = append(reg.manifests["addons/base:"+d], ' ')
+
+	r, err := New("ns/ca/base", srv.URL+"/addons/base", "v1", "user:secret", zap.Logger(true))
+	require.NoError(t, err)
+	defer r.Remove()
+
+	err = r.Update(context.Background())
+
+	assert.Error(t, err)
+	_, err = r.SHAlocal()
+	assert.Error(t, err, "expected nothing unpacked")
+}
+
+func TestRepo_Update_unauthorized(t *testing.T) {
+	reg := &testRegistry{t: t, user: "user", password: "secret"}
+	srv := httptest.NewServer(reg)
+	defer srv.Close()
+	reg.realm = srv.URL + "/token"
+	reg.push("addons/base", "v1", map[string]string{"a.yaml": "kind: A"})
+
+	r, err := New("ns/ca/base", srv.URL+"/addons/base", "v1", "user:wrong", zap.Logger(true))
+	require.NoError(t, err)
+	defer r.Remove()
+
+	err = r.Update(context.Background())
+
+	assert.Error(t, err)
+}
+
+// TestRegistry is a stand-in for an OCI registry that requires a bearer token.
+type testRegistry struct {
+	t              *testing.T
+	user, password string
+	realm          string
+
+	mu        sync.Mutex
+	manifests map[string][]byte
+	blobs     map[string][]byte
+	pulls     int
+	// Refs are the references of the manifests that have been pulled.
+	refs []string
+}
+
+// Push stores an artifact with files (path -> content) as repository:tag.
+func (reg *testRegistry) push(repository, tag string, files map[string]string) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if reg.manifests == nil {
+		reg.manifests = map[string][]byte{}
+		reg.blobs = map[string][]byte{}
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	for p, s := range files {
+		require.NoError(reg.t, tw.WriteHeader(&tar.Header{Name: p, Mode: 0644, Size: int64(len(s)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte(s))
+		require.NoError(reg.t, err)
+	}
+	require.NoError(reg.t, tw.Close())
+	require.NoError(reg.t, zw.Close())
+	layer := digestOf(buf.Bytes())
+	reg.blobs[layer] = buf.Bytes()
+
+	m, err := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     mediaTypeOCIManifest,
+		"config":        map[string]interface{}{"mediaType": "application/vnd.oci.image.config.v1+json", "digest": digestOf([]byte("{}")), "size": 2},
+		"layers": []interface{}{
+			map[string]interface{}{"mediaType": "application/vnd.oci.image.layer.v1.tar+gzip", "digest": layer, "size": buf.Len()},
+		},
+	})
+	require.NoError(reg.t, err)
+	reg.manifests[repository+":"+tag] = m
+	reg.manifests[repository+":"+digestOf(m)] = m
+}
+
+func (reg *testRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if req.URL.Path == "/token" {
+		user, password, ok := req.BasicAuth()
+		if !ok || user != reg.user || password != reg.password {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"token": "valid"})
+		return
+	}
+
+	if req.Header.Get("Authorization") != "Bearer valid" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="`+reg.realm+`",service="test"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	p := strings.TrimPrefix(req.URL.Path, "/v2/")
+	if i := strings.Index(p, "/manifests/"); i >= 0 {
+		m, ok := reg.manifests[p[:i]+":"+p[i+len("/manifests/"):]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", mediaTypeOCIManifest)
+		w.Header().Set("Docker-Content-Digest", digestOf(m))
+		if req.Method == http.MethodGet {
+			reg.pulls++
+			reg.refs = append(reg.refs, p[i+len("/manifests/"):])
+			_, _ = w.Write(m)
+		}
+		return
+	}
+	if i := strings.Index(p, "/blobs/"); i >= 0 {
+		b, ok := reg.blobs[p[i+len("/blobs/"):]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(b)
+		return
+	}
+	w.WriteHeader(http.StatusNotFound)
+}
+
+func digestOf(b []byte) string {
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,126 @@
+// Keep the output of action runs.
+package runlog
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Runlog keeps the full output of the last runs on disk.
+type Runlog struct {
+	// Dir is the directory that contains a subdirectory with log files per name.
+	Dir string
+	// Keep is the number of log files to keep per name.
+	Keep int
+}
+
+// TimeFormat is the format of the log file names, it sorts in chronological order.
+const timeFormat = "20060102T150405.000Z"
+
+// Write writes output of a run at time t to a log file for name and returns the path of the file.
+// The oldest log files of name are removed when there are more than Keep.
+func (r *Runlog) Write(name string, t time.Time, output string) (string, error) {
+	dir := filepath.Join(r.Dir, name)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", err
+	}
+
+	p := filepath.Join(dir, t.UTC().Format(timeFormat)+".log")
+	err = ioutil.WriteFile(p, []byte(output), 0644)
+	if err != nil {
+		return "", err
+	}
+
+	return p, r.prune(dir)
+}
+
+// Remove removes the log files of name, including the log files of the names below it.
+func (r *Runlog) Remove(name string) error {
+	if name == "" {
+		return fmt.Errorf("remove: name required")
+	}
+	return os.RemoveAll(filepath.Join(r.Dir, name))
+}
+
+// Prune removes the oldest log files in dir so Keep files remain.
+func (r *Runlog) prune(dir string) error {
+	fs, err := filepath.Glob(filepath.Join(dir, "*.log"))
+	if err != nil {
+		return err
+	}
+	if len(fs) <= r.Keep {
+		return nil
+	}
+
+	sort.Strings(fs)
+	for _, f := range fs[:len(fs)-r.Keep] {
+		err := os.Remove(f)
+		if err != nil {
+			return fmt.Errorf("prune: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Tail returns the last lines of s that fit in max bytes.
+// When s is truncated the result starts at a line boundary (if there is one).
+func Tail(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+
+	s = s[len(s)-max:]
+	if i := strings.IndexByte(s, '\n'); i >= 0 && i < len(s)-1 {
+		return s[i+1:]
+	}
+	// No line boundary, make sure the result doesn't start halfway an utf-8 character.
+	for len(s) > 0 && !utf8.RuneStart(s[0]) {
+		s = s[1:]
+	}
+	return s
+}
+
+// Head returns the first lines of s that fit in max bytes.
+// When s is truncated the result ends at a line boundary (if there is one).
+func Head(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+
+	s = s[:max]
+	if i := strings.LastIndexByte(s, '\n'); i > 0 {
+		return s[:i+1]
+	}
+	// No line boundary, make sure the result doesn't end halfway an utf-8 character.
+	for len(s) > 0 && !utf8.ValidString(s) {
+		s = s[:len(s)-1]
+	}
+	return s
+}
+
+// MinSecretLength is the minimal length of a secret to be masked.
+// Shorter secrets would mask too much of the output.
+const minSecretLength = 4
+
+// Mask replaces the secrets in s with '***'.
+func Mask(s string, secrets []string) string {
+	// Longest first so secrets that contain other secrets are masked completely.
+	ss := append([]string{}, secrets...)
+	sort.Slice(ss, func(i, j int) bool { return len(ss[i]) > len(ss[j]) })
+
+	for _, secret := range ss {
+		if len(secret) < minSecretLength {
+			continue
+		}
+		s = strings.ReplaceAll(s, secret, "***")
+	}
+	return s
+}
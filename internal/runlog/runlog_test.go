@@ -0,0 +1,106 @@
+package runlog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunlog_Write(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runlog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	r := &Runlog{Dir: dir, Keep: 2}
+	t0 := time.Unix(1000, 0)
+	var paths []string
+	for i := 0; i < 3; i++ {
+		p, err := r.Write("ns/name/source", t0.Add(time.Duration(i)*time.Second), "output")
+		require.NoError(t, err)
+		paths = append(paths, p)
+	}
+
+	fs, err := filepath.Glob(filepath.Join(dir, "ns/name/source", "*.log"))
+	require.NoError(t, err)
+	assert.Equal(t, paths[1:], fs, "oldest log is removed")
+	b, err := ioutil.ReadFile(paths[2])
+	require.NoError(t, err)
+	assert.Equal(t, "output", string(b))
+}
+
+func TestRunlog_Remove(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runlog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	r := &Runlog{Dir: dir, Keep: 2}
+	for _, n := range []string{"ns/a/base", "ns/a/ingress", "ns/b/base"} {
+		_, err := r.Write(n, time.Unix(1000, 0), "output")
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, r.Remove("ns/a/base"))
+	_, err = os.Stat(filepath.Join(dir, "ns/a/base"))
+	assert.True(t, os.IsNotExist(err), "expected ns/a/base removed")
+	assert.DirExists(t, filepath.Join(dir, "ns/a/ingress"))
+
+	require.NoError(t, r.Remove("ns/a"))
+	_, err = os.Stat(filepath.Join(dir, "ns/a"))
+	assert.True(t, os.IsNotExist(err), "expected ns/a removed")
+	assert.DirExists(t, filepath.Join(dir, "ns/b/base"))
+
+	assert.Error(t, r.Remove(""))
+	assert.DirExists(t, dir)
+}
+
+func TestTail(t *testing.T) {
+	tests := []struct {
+		s    string
+		max  int
+		want string
+	}{
+		{s: "one\ntwo\n", max: 100, want: "one\ntwo\n"},
+		{s: "one\ntwo\nthree\n", max: 9, want: "three\n"},
+		{s: "onetwothree", max: 5, want: "three"},
+		{s: "aé", max: 1, want: ""},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, Tail(tt.s, tt.max), tt.s)
+	}
+}
+
+func TestHead(t *testing.T) {
+	tests := []struct {
+		s    string
+		max  int
+		want string
+	}{
+		{s: "one\ntwo\n", max: 100, want: "one\ntwo\n"},
+		{s: "one\ntwo\nthree\n", max: 9, want: "one\ntwo\n"},
+		{s: "onetwothree", max: 5, want: "onetw"},
+		{s: "éa", max: 1, want: ""},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, Head(tt.s, tt.max), tt.s)
+	}
+}
+
+func TestMask(t *testing.T) {
+	tests := []struct {
+		s       string
+		secrets []string
+		want    string
+	}{
+		{s: "token=s3cret", secrets: []string{"s3cret"}, want: "token=***"},
+		{s: "token=s3cret-long", secrets: []string{"s3cret", "s3cret-long"}, want: "token=***"},
+		{s: "a=abc", secrets: []string{"abc", ""}, want: "a=abc"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, Mask(tt.s, tt.secrets), tt.s)
+	}
+}
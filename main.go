@@ -20,10 +20,12 @@ import (
 	"github.com/go-logr/glogr"
 	clusteropsv1alpha1 "github.com/mmlt/operator-addons/api/v1alpha1"
 	"github.com/mmlt/operator-addons/controllers"
+	"github.com/mmlt/operator-addons/internal/runlog"
 	"k8s.io/apimachinery/pkg/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	"os"
+	"path/filepath"
 	ctrl "sigs.k8s.io/controller-runtime"
 	// +kubebuilder:scaffold:imports
 )
@@ -41,12 +43,17 @@ func init() {
 }
 
 func main() {
-	var namespace, metricsAddr string
+	var namespace, metricsAddr, runlogDir, jobGitImage string
 	var enableLeaderElection bool
+	var runlogKeep int
 	flag.StringVar(&namespace, "namespace", "default", "The namespace to watch.")
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&runlogDir, "runlog-dir", filepath.Join(os.TempDir(), "runlog"), "The directory in which the output of action runs is kept.")
+	flag.IntVar(&runlogKeep, "runlog-keep", 5, "The number of action runs to keep the output of per source.")
+	flag.StringVar(&jobGitImage, "job-git-image", "alpine/git:v2.26.2",
+		"The image that clones git sources for actions that run as Job. When empty the source is copied into the Job via a ConfigMap.")
 	// glog
 	flag.Set("v", "5")
 	flag.Set("alsologtostderr", "true")
@@ -75,6 +82,9 @@ func main() {
 		Client: mgr.GetClient(),
 		Log:    ctrl.Log.WithName("controllers").WithName("ClusterAddon"),
 		Scheme: mgr.GetScheme(),
+		Runlog: &runlog.Runlog{Dir: runlogDir, Keep: runlogKeep},
+
+		JobGitImage: jobGitImage,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "ClusterAddon")
 		os.Exit(1)